@@ -0,0 +1,309 @@
+package core
+
+import (
+	"archive/zip"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/exp/slog"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var (
+	tempFolder        string
+	extractedArchives = make(map[string]string)
+)
+
+func IsZipArchive(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".zip")
+}
+
+// GetTempFolder создает (при первом вызове) приватную временную директорию cpmass
+func GetTempFolder() (string, error) {
+	if tempFolder != "" {
+		return tempFolder, nil
+	}
+
+	folder, err := os.MkdirTemp("", "cpmass-")
+	if err != nil {
+		return "", err
+	}
+	tempFolder = folder
+	slog.Debug(fmt.Sprintf("Temp folder %s created", tempFolder))
+	return tempFolder, nil
+}
+
+func CleanupTempFolder() {
+	if tempFolder == "" {
+		return
+	}
+
+	err := os.RemoveAll(tempFolder)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant remove temp folder[%s]: %s", tempFolder, err))
+		return
+	}
+	slog.Debug(fmt.Sprintf("Temp folder %s deleted", tempFolder))
+	tempFolder = ""
+	extractedArchives = make(map[string]string)
+}
+
+// SaveTempCertificate сохраняет сертификат в DER во временную директорию, например извлеченный из pfx
+func SaveTempCertificate(certificate *x509.Certificate, name string) (string, error) {
+	temp, err := GetTempFolder()
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp(temp, strings.TrimSuffix(name, filepath.Ext(name))+"-*.cer")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	_, err = file.Write(certificate.Raw)
+	return file.Name(), err
+}
+
+const (
+	zipFlagUTF8                = 0x800
+	zipExtraInfoZipUnicodePath = 0x7075
+)
+
+// zipUnicodePath возвращает имя в UTF-8 из расширенного поля Info-ZIP Unicode Path,
+// если поле есть и соответствует имени в заголовке
+func zipUnicodePath(file *zip.File) (string, bool) {
+	extra := file.Extra
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			break
+		}
+
+		data := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != zipExtraInfoZipUnicodePath || len(data) < 5 || data[0] != 1 {
+			continue
+		}
+
+		// CRC32 исходного имени защищает от устаревшего поля после переименования файла в архиве
+		name := string(data[5:])
+		if binary.LittleEndian.Uint32(data[1:5]) == crc32.ChecksumIEEE([]byte(file.Name)) && utf8.ValidString(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// decodeZipFilename возвращает имя файла в архиве. Кодировка определяется по флагу UTF-8 (бит 11),
+// а не по содержимому: имя в CP866 может оказаться корректной строкой UTF-8. Архиваторы Windows
+// сохраняют имена без флага UTF-8 в кодировке CP866
+func decodeZipFilename(file *zip.File) string {
+	if file.Flags&zipFlagUTF8 != 0 {
+		return file.Name
+	}
+
+	if name, ok := zipUnicodePath(file); ok {
+		return name
+	}
+
+	name, err := charmap.CodePage866.NewDecoder().String(file.Name)
+	if err != nil {
+		return file.Name
+	}
+	return name
+}
+
+func extractZipFile(file *zip.File, destination string) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := os.OpenFile(destination, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	_, err = io.Copy(writer, reader)
+	return err
+}
+
+// ExtractZipArchive распаковывает архив во временную директорию и возвращает путь до нее.
+// Повторный вызов для того же архива возвращает уже распакованную директорию.
+func ExtractZipArchive(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	if folder, ok := extractedArchives[absPath]; ok {
+		return folder, nil
+	}
+
+	if err := CheckManifestIntegrity(absPath); err != nil {
+		return "", err
+	}
+
+	archive, err := zip.OpenReader(absPath)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+
+	temp, err := GetTempFolder()
+	if err != nil {
+		return "", err
+	}
+
+	folder, err := os.MkdirTemp(temp, strings.TrimSuffix(filepath.Base(absPath), filepath.Ext(absPath))+"-")
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range archive.File {
+		name := decodeZipFilename(file)
+		destination := filepath.Join(folder, filepath.FromSlash(name))
+		if destination != folder && !strings.HasPrefix(destination, folder+string(filepath.Separator)) {
+			slog.Warn(fmt.Sprintf("Файл[%s] в архиве[%s] пропущен: путь выходит за пределы архива", name, filepath.Base(absPath)))
+			continue
+		}
+
+		if file.FileInfo().IsDir() {
+			err = os.MkdirAll(destination, 0700)
+			if err != nil {
+				return folder, err
+			}
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(destination), 0700)
+		if err != nil {
+			return folder, err
+		}
+
+		err = extractZipFile(file, destination)
+		if err != nil {
+			return folder, fmt.Errorf("cant extract %s from %s: %w", name, absPath, err)
+		}
+	}
+
+	extractedArchives[absPath] = folder
+	slog.Debug(fmt.Sprintf("Archive[%s] extracted to %s", absPath, folder))
+	return folder, nil
+}
+
+// ResolveArchivePath заменяет в пути архив .zip на директорию с его содержимым,
+// например certs/bundle.zip/Иванов/akimokyv.000 -> /tmp/cpmass-123/bundle-456/Иванов/akimokyv.000
+func ResolveArchivePath(path string) (string, error) {
+	if _, err := os.Stat(path); err == nil && !IsZipArchive(path) {
+		return path, nil
+	}
+
+	parts := strings.Split(filepath.Clean(path), string(filepath.Separator))
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], string(filepath.Separator))
+		if prefix == "" || !IsZipArchive(prefix) {
+			continue
+		}
+
+		info, err := os.Stat(prefix)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		folder, err := ExtractZipArchive(prefix)
+		if err != nil {
+			return path, err
+		}
+
+		rest := filepath.Join(parts[i+1:]...)
+		return ResolveArchivePath(filepath.Join(folder, rest))
+	}
+	return path, nil
+}
+
+// findSingleFileInFolder ищет в распакованном архиве единственный контейнер или сертификат
+func findSingleFileInFolder(folder string, container bool) (string, error) {
+	var found []string
+	_ = filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if container {
+			if !info.IsDir() && info.Name() == "header.key" {
+				found = append(found, filepath.Dir(path))
+			} else if !info.IsDir() && IsPfxFile(path) {
+				found = append(found, path)
+			}
+		} else if !info.IsDir() && isCertificateCandidate(info.Name()) {
+			if _, err := LoadCertificateFile(path); err == nil {
+				found = append(found, path)
+			}
+		}
+		return nil
+	})
+
+	if len(found) == 0 {
+		return folder, os.ErrNotExist
+	} else if len(found) > 1 {
+		return folder, errors.New("в архиве несколько подходящих файлов, укажите путь внутри архива")
+	}
+	return found[0], nil
+}
+
+// ResolveInstallPaths распаковывает архивы, указанные в путях до контейнера и сертификата
+func ResolveInstallPaths(installParams *ESignatureInstallParams) error {
+	originalContainerPath := installParams.ContainerPath
+	containerPath, err := ResolveArchivePath(installParams.ContainerPath)
+	if err != nil {
+		return err
+	}
+
+	if containerPath != originalContainerPath {
+		info, err := os.Stat(containerPath)
+		if err == nil && info.IsDir() {
+			if _, err := os.Stat(filepath.Join(containerPath, "header.key")); err != nil {
+				containerPath, err = findSingleFileInFolder(containerPath, true)
+				if err != nil {
+					return fmt.Errorf("не удалось найти контейнер в архиве[%s]: %w", originalContainerPath, err)
+				}
+			}
+		}
+	}
+	installParams.ContainerPath = containerPath
+
+	if installParams.CertificatePath == "" {
+		return nil
+	}
+
+	originalCertificatePath := installParams.CertificatePath
+	certificatePath, err := ResolveArchivePath(installParams.CertificatePath)
+	if err != nil {
+		return err
+	}
+
+	if certificatePath != originalCertificatePath {
+		info, err := os.Stat(certificatePath)
+		if err == nil && info.IsDir() {
+			certificatePath, err = findSingleFileInFolder(certificatePath, false)
+			if err != nil {
+				return fmt.Errorf("не удалось найти сертификат в архиве[%s]: %w", originalCertificatePath, err)
+			}
+		}
+	}
+	installParams.CertificatePath = certificatePath
+	return nil
+}
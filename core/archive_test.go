@@ -0,0 +1,164 @@
+package core
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func writeTestZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := entry.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractZipArchive(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Cleanup(CleanupTempFolder)
+
+	archivePath := filepath.Join(t.TempDir(), "bundle.zip")
+	writeTestZip(t, archivePath, map[string]string{
+		"Иванов/cert.cer":            "certificate",
+		"Иванов/akimokyv.000/":       "",
+		"../evil.txt":                "outside",
+		"Иванов/../../evil2.txt":     "outside",
+		"Иванов/../inside.txt":       "inside",
+		"/absolute.txt":              "absolute",
+		"../bundle-outside/evil.txt": "outside",
+	})
+
+	folder, err := ExtractZipArchive(archivePath)
+	if err != nil {
+		t.Fatalf("ExtractZipArchive() error: %s", err)
+	}
+
+	tests := []struct {
+		path   string
+		exists bool
+	}{
+		{filepath.Join(folder, "Иванов", "cert.cer"), true},
+		{filepath.Join(folder, "Иванов", "akimokyv.000"), true},
+		{filepath.Join(folder, "inside.txt"), true},
+		{filepath.Join(folder, "absolute.txt"), true},
+		{filepath.Join(filepath.Dir(folder), "evil.txt"), false},
+		{filepath.Join(filepath.Dir(folder), "evil2.txt"), false},
+		{filepath.Join(filepath.Dir(folder), "bundle-outside"), false},
+	}
+
+	for _, test := range tests {
+		_, err := os.Stat(test.path)
+		if exists := err == nil; exists != test.exists {
+			t.Errorf("%s exists = %t, want %t", test.path, exists, test.exists)
+		}
+	}
+
+	again, err := ExtractZipArchive(archivePath)
+	if err != nil || again != folder {
+		t.Errorf("ExtractZipArchive() second call = %q, %v, want %q", again, err, folder)
+	}
+}
+
+func encodeTestCp866(t *testing.T, name string) string {
+	t.Helper()
+
+	encoded, err := charmap.CodePage866.NewEncoder().String(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func newTestUnicodePathExtra(rawName string, name string) []byte {
+	extra := make([]byte, 9, 9+len(name))
+	binary.LittleEndian.PutUint16(extra[0:2], zipExtraInfoZipUnicodePath)
+	binary.LittleEndian.PutUint16(extra[2:4], uint16(5+len(name)))
+	extra[4] = 1
+	binary.LittleEndian.PutUint32(extra[5:9], crc32.ChecksumIEEE([]byte(rawName)))
+	return append(extra, name...)
+}
+
+func TestDecodeZipFilename(t *testing.T) {
+	cp866Name := encodeTestCp866(t, "Иванов.cer")
+	// Имя в CP866, которое одновременно является корректной строкой UTF-8
+	cp866Utf8Name := encodeTestCp866(t, "сПб.cer")
+	if !utf8.ValidString(cp866Utf8Name) {
+		t.Fatalf("%q is not valid UTF-8", cp866Utf8Name)
+	}
+
+	tests := []struct {
+		name   string
+		header zip.FileHeader
+		result string
+	}{
+		{"флаг UTF-8", zip.FileHeader{Name: "Иванов.cer", Flags: zipFlagUTF8}, "Иванов.cer"},
+		{"ASCII", zip.FileHeader{Name: "ivanov/cert.cer"}, "ivanov/cert.cer"},
+		{"CP866", zip.FileHeader{Name: cp866Name}, "Иванов.cer"},
+		{"CP866 похожее на UTF-8", zip.FileHeader{Name: cp866Utf8Name}, "сПб.cer"},
+		{"Info-ZIP Unicode Path", zip.FileHeader{Name: cp866Name, Extra: newTestUnicodePathExtra(cp866Name, "Петров.cer")}, "Петров.cer"},
+		{"устаревшее поле Unicode Path", zip.FileHeader{Name: cp866Name, Extra: newTestUnicodePathExtra("old.cer", "Петров.cer")}, "Иванов.cer"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if name := decodeZipFilename(&zip.File{FileHeader: test.header}); name != test.result {
+				t.Errorf("decodeZipFilename(%q) = %q, want %q", test.header.Name, name, test.result)
+			}
+		})
+	}
+}
+
+func TestExtractZipArchiveCp866(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Cleanup(CleanupTempFolder)
+
+	archivePath := filepath.Join(t.TempDir(), "cp866.zip")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := zip.NewWriter(file)
+	// NonUTF8 запрещает zip.Writer выставлять флаг UTF-8, как это делают архиваторы Windows
+	if _, err := writer.CreateHeader(&zip.FileHeader{Name: encodeTestCp866(t, "сПб.cer"), NonUTF8: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	folder, err := ExtractZipArchive(archivePath)
+	if err != nil {
+		t.Fatalf("ExtractZipArchive() error: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(folder, "сПб.cer")); err != nil {
+		t.Errorf("CP866 name is not decoded: %s", err)
+	}
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	DISCOVERY_CACHE_FILENAME = "discovery-cache.json"
+	discoveryCacheVersion    = 1
+
+	DISCOVERY_KIND_CERTIFICATE = "certificate"
+	DISCOVERY_KIND_CONTAINER   = "container"
+	DISCOVERY_KIND_PFX         = "pfx"
+)
+
+var discoveryCachePath string
+
+// discoveryCacheEntry хранит результат разбора файла. Пустой PublicKey означает,
+// что файл уже проверялся и не является сертификатом пользователя (или pfx без сертификата).
+type discoveryCacheEntry struct {
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"mtime"`
+	PublicKey   string `json:"publicKey,omitempty"`
+	Certificate []byte `json:"certificate,omitempty"`
+}
+
+// discoveryCache - кэш открытых ключей и сертификатов, ключ записи - тип файла и путь, запись
+// действительна пока не изменились размер и время изменения файла
+type discoveryCache struct {
+	mu      sync.Mutex
+	path    string
+	Version int                             `json:"version"`
+	Entries map[string]*discoveryCacheEntry `json:"entries"`
+	used    map[string]*discoveryCacheEntry
+	hits    int
+	misses  int
+}
+
+func SetDiscoveryCachePath(path string) {
+	discoveryCachePath = path
+}
+
+// GetDiscoveryCachePath возвращает путь до файла кэша, пустая строка - кэш отключен
+func GetDiscoveryCachePath() string {
+	return discoveryCachePath
+}
+
+func loadDiscoveryCache(path string) *discoveryCache {
+	if path == "" {
+		return nil
+	}
+
+	cache := &discoveryCache{
+		path:    path,
+		Version: discoveryCacheVersion,
+		Entries: make(map[string]*discoveryCacheEntry),
+		used:    make(map[string]*discoveryCacheEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Debug(fmt.Sprintf("Cant read discovery cache %s: %v", path, err))
+		}
+		return cache
+	}
+
+	var stored discoveryCache
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Version != discoveryCacheVersion || stored.Entries == nil {
+		slog.Debug(fmt.Sprintf("Discovery cache %s is outdated or broken, ignored", path))
+		return cache
+	}
+	cache.Entries = stored.Entries
+	return cache
+}
+
+// Файлы из распакованных архивов лежат во временной папке с новым именем при каждом запуске
+func isDiscoveryCacheable(path string) bool {
+	return tempFolder == "" || !strings.HasPrefix(path, tempFolder)
+}
+
+func discoveryCacheKey(kind string, path string) string {
+	return kind + ":" + path
+}
+
+func (c *discoveryCache) get(kind string, path string, info os.FileInfo) (*discoveryCacheEntry, bool) {
+	if c == nil || !isDiscoveryCacheable(path) {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := discoveryCacheKey(kind, path)
+	entry, ok := c.Entries[key]
+	if !ok || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.used[key] = entry
+	return entry, true
+}
+
+func (c *discoveryCache) put(kind string, path string, info os.FileInfo, entry *discoveryCacheEntry) {
+	if c == nil || !isDiscoveryCacheable(path) {
+		return
+	}
+
+	entry.Size = info.Size()
+	entry.ModTime = info.ModTime().UnixNano()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used[discoveryCacheKey(kind, path)] = entry
+}
+
+// save записывает только записи, использованные при последнем поиске, удаленные файлы из кэша пропадают
+func (c *discoveryCache) save() {
+	if c == nil {
+		return
+	}
+
+	slog.Debug(fmt.Sprintf("Discovery cache: %d hits, %d misses", c.hits, c.misses))
+
+	data, err := json.Marshal(&discoveryCache{Version: discoveryCacheVersion, Entries: c.used})
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant encode discovery cache: %v", err))
+		return
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant save discovery cache %s: %v", c.path, err))
+		return
+	}
+
+	_, err = temp.Write(data)
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), c.path)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+		slog.Debug(fmt.Sprintf("Cant save discovery cache %s: %v", c.path, err))
+	}
+}
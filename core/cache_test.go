@@ -0,0 +1,141 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path string, content string) os.FileInfo {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	folder := t.TempDir()
+	cachePath := filepath.Join(folder, DISCOVERY_CACHE_FILENAME)
+	path := filepath.Join(folder, "a.cer")
+	otherPath := filepath.Join(folder, "b.cer")
+	info := writeTestFile(t, path, "certificate")
+	writeTestFile(t, otherPath, "certificate")
+
+	cache := loadDiscoveryCache(cachePath)
+	cache.put(DISCOVERY_KIND_CERTIFICATE, path, info, &discoveryCacheEntry{PublicKey: "key"})
+	cache.save()
+
+	cache = loadDiscoveryCache(cachePath)
+	entry, ok := cache.get(DISCOVERY_KIND_CERTIFICATE, path, info)
+	if !ok || entry.PublicKey != "key" {
+		t.Fatalf("get() = %v, %t, want cached entry", entry, ok)
+	}
+
+	otherInfo, _ := os.Stat(otherPath)
+	if _, ok := cache.get(DISCOVERY_KIND_CERTIFICATE, otherPath, otherInfo); ok {
+		t.Error("get() for other path hit")
+	}
+
+	if _, ok := cache.get(DISCOVERY_KIND_PFX, path, info); ok {
+		t.Error("get() for other kind hit")
+	}
+
+	writeTestFile(t, path, "certificate2")
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	resized, _ := os.Stat(path)
+	if _, ok := cache.get(DISCOVERY_KIND_CERTIFICATE, path, resized); ok {
+		t.Error("get() after size change hit")
+	}
+
+	writeTestFile(t, path, "certificate")
+	modTime := info.ModTime().Add(time.Minute)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	touched, _ := os.Stat(path)
+	if _, ok := cache.get(DISCOVERY_KIND_CERTIFICATE, path, touched); ok {
+		t.Error("get() after mtime change hit")
+	}
+}
+
+func TestDiscoveryCacheDisabled(t *testing.T) {
+	folder := t.TempDir()
+	path := filepath.Join(folder, "a.cer")
+	info := writeTestFile(t, path, "certificate")
+
+	cache := loadDiscoveryCache("")
+	if cache != nil {
+		t.Fatalf("loadDiscoveryCache(\"\") = %v, want nil", cache)
+	}
+
+	cache.put(DISCOVERY_KIND_CERTIFICATE, path, info, &discoveryCacheEntry{PublicKey: "key"})
+	if _, ok := cache.get(DISCOVERY_KIND_CERTIFICATE, path, info); ok {
+		t.Error("get() on disabled cache hit")
+	}
+	cache.save()
+
+	if _, err := FindDigitalSignaturePairs(folder, DiscoveryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(folder, DISCOVERY_CACHE_FILENAME)); err == nil {
+		t.Error("cache file created with disabled cache")
+	}
+}
+
+func TestDiscoveryCacheBroken(t *testing.T) {
+	folder := t.TempDir()
+	cachePath := filepath.Join(folder, DISCOVERY_CACHE_FILENAME)
+	path := filepath.Join(folder, "a.cer")
+	info := writeTestFile(t, path, "certificate")
+
+	for _, content := range []string{"{broken", `{"version": 0, "entries": {}}`, `{"version": 1}`} {
+		writeTestFile(t, cachePath, content)
+
+		cache := loadDiscoveryCache(cachePath)
+		if cache == nil || len(cache.Entries) != 0 {
+			t.Fatalf("loadDiscoveryCache(%q) = %v, want empty cache", content, cache)
+		}
+
+		cache.put(DISCOVERY_KIND_CERTIFICATE, path, info, &discoveryCacheEntry{PublicKey: "key"})
+		cache.save()
+
+		if _, ok := loadDiscoveryCache(cachePath).get(DISCOVERY_KIND_CERTIFICATE, path, info); !ok {
+			t.Errorf("broken cache %q was not replaced", content)
+		}
+	}
+}
+
+func TestLoadDiscoveredCertificateReadError(t *testing.T) {
+	folder := t.TempDir()
+	cache := loadDiscoveryCache(filepath.Join(folder, DISCOVERY_CACHE_FILENAME))
+
+	// Директория проходит os.Stat, но не читается как файл
+	unreadable := filepath.Join(folder, "unreadable.cer")
+	if err := os.Mkdir(unreadable, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if certificate, _ := loadDiscoveredCertificate(unreadable, cache); certificate != nil {
+		t.Fatal("loadDiscoveredCertificate() returned certificate for unreadable file")
+	}
+	if len(cache.used) != 0 {
+		t.Errorf("read error cached: %v", cache.used)
+	}
+
+	text := filepath.Join(folder, "text.cer")
+	writeTestFile(t, text, "not a certificate")
+	loadDiscoveredCertificate(text, cache)
+	if entry, ok := cache.used[discoveryCacheKey(DISCOVERY_KIND_CERTIFICATE, text)]; !ok || entry.PublicKey != "" {
+		t.Errorf("parsed file not cached as not a certificate: %v", entry)
+	}
+}
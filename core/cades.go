@@ -1,16 +1,19 @@
 package core
 
 import (
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
 	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
 	"github.com/google/uuid"
+	cp "github.com/otiai10/copy"
 	"golang.org/x/exp/slog"
 )
 
@@ -72,6 +75,33 @@ func DeleteESignature(thumbprint string, container *cades.Container) *DeleteCert
 	return result
 }
 
+var ErrPfxKeyMismatch = errors.New("pfx key does not match certificate")
+
+// ConfirmPfxKey сравнивает открытый ключ сертификата, установленного certmgr из pfx, с ключом сертификата подписи.
+// Pfx с зашифрованным сертификатом сопоставляется с сертификатом по имени файла, поэтому ключ проверяется после установки.
+func ConfirmPfxKey(pfxResult *cades.InstallPfxResult, certificate *x509.Certificate) error {
+	if pfxResult.Thumbprint == "" || strings.EqualFold(pfxResult.Thumbprint, cades.GetThumbprint(certificate)) {
+		return nil
+	}
+
+	m := cades.CadesManager{}
+	installed, err := m.GetCertificatesInfo(pfxResult.Thumbprint, "")
+	if err != nil || len(installed) == 0 || installed[0].ShortPublicKey == "" {
+		slog.Debug(fmt.Sprintf("Cant get public key of pfx certificate[%s]: %v", pfxResult.Thumbprint, err))
+		return nil
+	}
+
+	subjectPublicKeyInfo, err := cades.ParseSubjectPublicKeyInfo(certificate)
+	if err != nil {
+		return nil
+	}
+
+	if !strings.EqualFold(installed[0].ShortPublicKey, cades.GetCertificateShortPublicKey(subjectPublicKeyInfo)) {
+		return ErrPfxKeyMismatch
+	}
+	return nil
+}
+
 func InstallContainerFromPfx(path string, password string, exportable bool) (*cades.InstallPfxResult, error) {
 	m := cades.CadesManager{}
 	result, err := m.InstallPfx(path, password, exportable)
@@ -80,16 +110,185 @@ func InstallContainerFromPfx(path string, password string, exportable bool) (*ca
 	return result, err
 }
 
-func InstallContainerFromFolder(path string, rootContainersFolder string, containerName string) (*cades.Container, error) {
+const (
+	STORE_REGISTRY = "REGISTRY"
+	STORE_HDIMAGE  = "HDIMAGE"
+)
+
+var ErrReaderNotFound = errors.New("reader not found")
+
+func defaultContainerStorage() string {
+	if runtime.GOOS == "windows" {
+		return STORE_REGISTRY
+	}
+	return STORE_HDIMAGE
+}
+
+// ContainerReader возвращает считыватель из полного имени контейнера: \\.\HDIMAGE\name -> HDIMAGE
+func ContainerReader(container *cades.Container) string {
+	name := container.ContainerName
+	if name == "" {
+		name = container.UniqueContainerName
+	}
+
+	name = strings.TrimPrefix(name, `\\.\`)
+	return strings.Split(name, `\`)[0]
+}
+
+func containerShortName(container *cades.Container) string {
+	containerNameRaw := strings.Split(container.ContainerName, `\`)
+	return containerNameRaw[len(containerNameRaw)-1]
+}
+
+var cachedReaders string
+
+// IsReaderExists проверяет наличие считывателя (хранилища ключей) в списке считывателей КриптоПро CSP
+func IsReaderExists(reader string) (bool, error) {
+	if cachedReaders == "" {
+		output, err := cades.NewCSPTestProcess("-enum", "-info", "-type", "PP_ENUMREADERS")
+		if err != nil {
+			slog.Debug(fmt.Sprintf("Cant get list of readers: %s", output))
+			return false, err
+		}
+		cachedReaders = output
+	}
+
+	for _, line := range strings.Split(cachedReaders, "\n") {
+		if strings.EqualFold(strings.TrimSpace(line), reader) || strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), strings.ToUpper(reader)+" ") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MoveContainer переносит контейнер в другой считыватель с сохранением имени
+func MoveContainer(container *cades.Container, reader string, pin string) (*cades.Container, error) {
+	location := fmt.Sprintf(`\\.\%s\%s`, reader, containerShortName(container))
+	result, err := CopyContainer(container, location, pin)
+	if err != nil {
+		return result, err
+	}
+
+	DeleteContainer(container)
+	return result, nil
+}
+
+// InstallContainerFromFolder устанавливает контейнер из папки.
+// CadesManager копирует только контейнеры без PIN-кода, поэтому контейнер с PIN-кодом копируется через CopyContainer.
+func InstallContainerFromFolder(path string, rootContainersFolder string, store string, containerName string, pin string) (*cades.Container, error) {
 	m := cades.CadesManager{}
-	result, err := m.InstallContainerFromFolder(path, rootContainersFolder, "", containerName)
+	if pin == "" {
+		result, err := m.InstallContainerFromFolder(path, rootContainersFolder, store, containerName)
+		slog.Debug(fmt.Sprintf("Install Container from folder result: %+v", result))
+		return result, err
+	}
+
+	containerFolderName := filepath.Base(path)
+	newContainerPath := filepath.Join(rootContainersFolder, containerFolderName)
+	if _, err := os.Stat(newContainerPath); err != nil {
+		if err := cp.Copy(path, newContainerPath); err != nil {
+			return &cades.Container{}, err
+		}
+	}
+
+	if runtime.GOOS == "linux" {
+		defer func() {
+			if err := os.RemoveAll(newContainerPath); err != nil {
+				slog.Debug(fmt.Sprintf("Cant remove a copy of the container folder: %s; error: %s", newContainerPath, err))
+			}
+		}()
+	}
+
+	container, err := m.GetContainer(containerFolderName)
+	if err != nil {
+		slog.Debug(err.Error())
+		return container, err
+	}
+
+	if containerName == "" {
+		containerName = containerShortName(container)
+	}
+
+	if store == "" {
+		store = defaultContainerStorage()
+	}
+
+	location := fmt.Sprintf(`\\.\%s\%s mi`, store, containerName)
+	result, err := CopyContainer(container, location, pin)
 	slog.Debug(fmt.Sprintf("Install Container from folder result: %+v", result))
 	return result, err
 }
 
+// CopyContainer копирует контейнер, PIN-код исходного контейнера сохраняется у копии
+func CopyContainer(container *cades.Container, location string, pin string) (*cades.Container, error) {
+	m := cades.CadesManager{}
+	if pin == "" {
+		return m.CopyContainer(container, location)
+	}
+
+	output, err := cades.NewCSPTestProcess(
+		"-keycopy", "-contsrc", container.UniqueContainerName, "-contdest", location,
+		"-pinsrc", pin, "-pindest", pin, "-silent",
+	)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Output log: %s", output))
+		if strings.Contains(output, "ErrorCode: 0x8009000b") {
+			return &cades.Container{}, cades.ErrContainerNotExportable
+		} else if !strings.Contains(output, "ErrorCode: 0x8009000f") {
+			return &cades.Container{}, err
+		}
+
+		existingContainer, err := m.GetContainer(location)
+		if err != nil {
+			return existingContainer, err
+		}
+		return existingContainer, cades.ErrContainerExists
+	}
+
+	return m.GetContainer(location)
+}
+
+// ChangeContainerPin устанавливает новый PIN-код контейнера, пустой newPin снимает PIN-код
+func ChangeContainerPin(container *cades.Container, oldPin string, newPin string) error {
+	args := []string{"-passwd", "-container", container.UniqueContainerName, "-change", newPin}
+	if oldPin != "" {
+		args = append(args, "-passwd", oldPin)
+	}
+
+	output, err := cades.NewCSPTestProcess(append(args, "-silent")...)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Output log: %s", output))
+		return err
+	}
+	return nil
+}
+
 var cachedUserSid string
 
-func RenameContainer(container *cades.Container, containerName ContainerName) (*cades.Container, error) {
+// renameContainerWithUtils переименовывает контейнер копированием через утилиты КриптоПро
+func renameContainerWithUtils(container *cades.Container, newContainerName string, pin string) (*cades.Container, error) {
+	m := cades.CadesManager{}
+	if pin == "" {
+		return m.RenameContainer(container, newContainerName)
+	}
+
+	location := fmt.Sprintf(`\\.\%s\%s`, ContainerReader(container), newContainerName)
+	if location == container.ContainerName || location == container.UniqueContainerName {
+		return container, nil
+	}
+
+	result, err := CopyContainer(container, location, pin)
+	if errors.Is(err, cades.ErrContainerExists) {
+		return container, nil
+	} else if err != nil {
+		return result, err
+	}
+
+	DeleteContainer(container)
+	return result, nil
+}
+
+func RenameContainer(container *cades.Container, containerName ContainerName, pin string) (*cades.Container, error) {
 	m := cades.CadesManager{}
 	user, err := user.Current()
 
@@ -103,10 +302,12 @@ func RenameContainer(container *cades.Container, containerName ContainerName) (*
 
 	if err != nil {
 		slog.Debug(fmt.Sprintf("Cant get username for direct rename, use cryptopro utils: %s", err))
-		return m.RenameContainer(container, containerName.Normal)
+		return renameContainerWithUtils(container, containerName.Normal, pin)
 	}
 
-	if strings.Contains(container.UniqueContainerName, "REGISTRY") {
+	// Прямое переименование доступно только для хранилищ в реестре и на диске
+	reader := ContainerReader(container)
+	if reader == STORE_REGISTRY {
 		if user.Uid != "" {
 			cachedUserSid = user.Uid
 		} else if cachedUserSid == "" {
@@ -121,16 +322,13 @@ func RenameContainer(container *cades.Container, containerName ContainerName) (*
 
 		if cachedUserSid == "" {
 			slog.Debug(fmt.Sprintf("Cant get user sid for direct rename, use cryptopro utils: %s", err))
-			return m.RenameContainer(container, containerName.Normal)
+			return renameContainerWithUtils(container, containerName.Normal, pin)
 		}
 
-		containerNameRaw := strings.Split(container.ContainerName, `\`)
-		currentContainerName := containerNameRaw[len(containerNameRaw)-1]
-
-		ok, err := cades.DirectRenameContainerRegistry(cachedUserSid, currentContainerName, containerName.Windows1251)
+		ok, err := cades.DirectRenameContainerRegistry(cachedUserSid, containerShortName(container), containerName.Windows1251)
 		if !ok {
 			slog.Debug(fmt.Sprintf("Error in DirectRenameContainerRegistry, use cryptopro utils: %s", err))
-			return m.RenameContainer(container, containerName.Normal)
+			return renameContainerWithUtils(container, containerName.Normal, pin)
 		}
 
 		return &cades.Container{
@@ -139,24 +337,29 @@ func RenameContainer(container *cades.Container, containerName ContainerName) (*
 		}, nil
 	}
 
-	if strings.Contains(container.UniqueContainerName, "HDIMAGE") {
+	if reader == STORE_HDIMAGE {
 		ok, err := cades.DirectRenameContainerHDImage(username, container.UniqueContainerName, containerName.Windows1251)
 		if err != nil {
 			slog.Debug(fmt.Sprintf("Error in DirectRenameContainerHDImage, use cryptopro utils: %s", err))
-			return m.RenameContainer(container, containerName.Normal)
+			return renameContainerWithUtils(container, containerName.Normal, pin)
 		}
 
 		if ok {
 			newContainer, err := m.GetContainer(fmt.Sprintf(`\\.\HDIMAGE\%s`, containerName.Normal))
 			if err != nil {
 				slog.Debug(fmt.Sprintf("Error after DirectRenameContainerHDImage, use cryptopro utils: %s", err))
-				return m.RenameContainer(container, containerName.Normal)
+				return renameContainerWithUtils(container, containerName.Normal, pin)
 			}
 			return newContainer, nil
 		}
 	}
 
-	return m.RenameContainer(container, containerName.Normal)
+	return renameContainerWithUtils(container, containerName.Normal, pin)
+}
+
+func GetListOfContainers() ([]cades.Container, error) {
+	m := cades.CadesManager{}
+	return m.GetListOfContainers()
 }
 
 func GetContainer(containerName string) (*cades.Container, error) {
@@ -178,20 +381,21 @@ func ExportContainerToPfxByThumbprint(container *cades.Container, thumbprint str
 	return filePath, nil
 }
 
-func LinkCertWithContainer(path, containerName string) (bool, error) {
+func LinkCertWithContainer(path, containerName string, pin string) (bool, error) {
 	m := cades.CadesManager{}
-	result, err := m.LinkCertWithContainer(path, containerName)
+	if pin == "" {
+		result, err := m.LinkCertWithContainer(path, containerName)
+		slog.Debug(fmt.Sprintf("Link certificate with container result: %+v", result))
+		return result, err
+	}
+
+	output, err := cades.NewCertManagerProcess("-inst", "-inst_to_cont", "-file", path, "-cont", containerName, "-pin", pin, "-silent")
+	result := strings.Contains(output, "[ErrorCode: 0x00000000]")
 	slog.Debug(fmt.Sprintf("Link certificate with container result: %+v", result))
 
 	return result, err
 }
 
-func InstallRootCertificate(path string) error {
-	m := cades.CadesManager{}
-	err := m.InstallCertificate(path, "uRoot", false)
-	return err
-}
-
 func AbsorbCertificatesFromContainers() error {
 	m := cades.CadesManager{}
 	_, err := m.AbsorbCertificates("")
@@ -199,11 +403,18 @@ func AbsorbCertificatesFromContainers() error {
 }
 
 type ESignatureInstallParams struct {
-	ContainerPath   string  `json:"containerPath" csv:"pfx,container"`
-	ContainerName   string  `json:"name,omitempty"`
-	CertificatePath string  `json:"certificatePath" csv:"cert"`
-	PfxPassword     *string `json:"pfxPassword,omitempty" csv:"password,pfx_password,omitempty"`
-	Exportable      *bool   `json:"exportable,omitempty"`
+	ContainerPath          string   `json:"containerPath" csv:"pfx,container"`
+	ContainerName          string   `json:"name,omitempty"`
+	CertificatePath        string   `json:"certificatePath" csv:"cert"`
+	ExtraCertificates      []string `json:"extraCertificates,omitempty" csv:"-"`
+	InstallAllCertificates *bool    `json:"installAllCertificates,omitempty" csv:"-"`
+	PfxPassword            *string  `json:"pfxPassword,omitempty" csv:"password,pfx_password,omitempty"`
+	Store                  string   `json:"store,omitempty" csv:"store,reader,omitempty"`
+	NameCollisionPolicy    string   `json:"nameCollisionPolicy,omitempty" csv:"name_collision,omitempty"`
+	ContainerPin           *string  `json:"containerPin,omitempty" csv:"pin,container_pin,omitempty"`
+	NewContainerPin        *string  `json:"newContainerPin,omitempty" csv:"new_pin,omitempty"`
+	ClearContainerPin      *bool    `json:"clearContainerPin,omitempty" csv:"clear_pin,omitempty"`
+	Exportable             *bool    `json:"exportable,omitempty"`
 }
 
 func InstallESignature(rootContainersFolder string, installParams *ESignatureInstallParams) error {
@@ -211,60 +422,92 @@ func InstallESignature(rootContainersFolder string, installParams *ESignatureIns
 	certificateFilename := filepath.Base(installParams.CertificatePath)
 	containerFilename := filepath.Base(installParams.ContainerPath)
 
+	if _, err := ParseNameCollisionPolicy(installParams.NameCollisionPolicy); err != nil {
+		slog.Error(fmt.Sprintf("Неизвестная политика совпадения имени[%s], допустимо suffix, thumbprint, overwrite или skip", installParams.NameCollisionPolicy))
+		return err
+	}
+
 	if _, err := os.Stat(installParams.ContainerPath); errors.Is(err, os.ErrNotExist) {
 		slog.Debug(err.Error())
 		slog.Error(fmt.Sprintf("Файл/Директория контейнера не найден: %s", installParams.ContainerPath))
 		return err
 	}
 
+	if installParams.CertificatePath == "" {
+		pfxPassword := ""
+		if IsPfxFile(installParams.ContainerPath) {
+			pfxPassword = ResolvePfxPassword(installParams.PfxPassword, installParams.ContainerPin)
+		}
+
+		certificatePath, err := ExtractEmbeddedCertificate(installParams.ContainerPath, pfxPassword)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Не указан сертификат, в контейнере[%s] сертификат не найден", containerFilename))
+			slog.Debug(fmt.Sprintf("Cant extract certificate from container[%s]: %s", installParams.ContainerPath, err))
+			return err
+		}
+		slog.Info(fmt.Sprintf("Используется сертификат из контейнера[%s]", containerFilename))
+		installParams.CertificatePath = certificatePath
+		certificateFilename = containerFilename
+	}
+
 	if _, err := os.Stat(installParams.CertificatePath); errors.Is(err, os.ErrNotExist) {
 		slog.Error(fmt.Sprintf("Файл сертификата не найден: %s", installParams.CertificatePath))
 		return err
 	}
 
-	thumbprint, err := cades.GetCertificateThumbprintFromFile(installParams.CertificatePath)
+	for _, path := range []string{installParams.CertificatePath, installParams.ContainerPath} {
+		if err := CheckManifestIntegrity(path); err != nil {
+			slog.Error(fmt.Sprintf("Подпись пропущена: %s", err))
+			return err
+		}
+	}
+
+	certificateX509, err := LoadCertificateFile(installParams.CertificatePath)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Не удалось получить thumbprint сертификата[%s]", certificateFilename))
+		slog.Error(fmt.Sprintf("Не удалось прочитать сертификат[%s]", installParams.CertificatePath))
+		slog.Debug(fmt.Sprintf("Cant load[%s]: %s", installParams.CertificatePath, err))
 		return err
 	}
 
+	thumbprint := cades.GetThumbprint(certificateX509)
 	ok, err := IsCertificateWithContainerExists(thumbprint, "")
 	if ok {
 		slog.Warn(fmt.Sprintf("Контейнер с сертификатом[%s] существует в хранилище.", certificateFilename))
 		return err
 	}
 
-	certificateRaw, err := os.ReadFile(installParams.CertificatePath)
+	// certmgr не принимает сертификаты в base64 без заголовков
+	certificatePath, err := NormalizeCertificateFile(installParams.CertificatePath)
 	if err != nil {
 		slog.Error(fmt.Sprintf("Не удалось прочитать сертификат[%s]", installParams.CertificatePath))
-		slog.Debug(fmt.Sprintf("Cant read[%s]: %s", installParams.CertificatePath, err))
+		slog.Debug(fmt.Sprintf("Cant normalize[%s]: %s", installParams.CertificatePath, err))
 		return err
 	}
+	installParams.CertificatePath = certificatePath
 
-	certificateX509, err := cades.LoadCertificate(certificateRaw)
-	if err != nil {
-		slog.Error(fmt.Sprintf("Не удалось прочитать сертификат[%s]", installParams.CertificatePath))
-		slog.Debug(fmt.Sprintf("Cant load[%s]: %s", installParams.CertificatePath, err))
-		return err
-	}
-
-	gostCertificate, err := cades.ParseGostCertificate(certificateX509)
+	nameCertificate, err := NewNameCertificate(certificateX509)
 	if err != nil {
 		slog.Error(fmt.Sprintf("Не удалось прочитать сертификат[%s]", installParams.CertificatePath))
 		slog.Debug(fmt.Sprintf("Cant parse[%s]: %s", installParams.CertificatePath, err))
 		return err
 	}
 
-	containerSubject := FormatNewName("#subject.surname #subject.initials - #subject.title", gostCertificate)
+	gostCertificate := nameCertificate.GostCertificate
+	// Владелец подписи только выводится в журнал, поэтому изменения имени не логируются
+	var containerSubject string
+	if rendered, err := RenderNamePattern("#subject.surname #subject.initials - #subject.title", nameCertificate); err == nil {
+		containerSubject, _ = SanitizeContainerName(rendered.Text)
+	}
 
-	now := time.Now()
-	if now.After(gostCertificate.NotAfter) {
-		slog.Warn(fmt.Sprintf(
-			"У сертификата[%s] истек срок действия (был действителен до %s)",
-			certificateFilename, gostCertificate.NotAfter.Format("02.01.2006 15:04:05"),
-		))
+	if err := CheckCertificate(certificateX509, gostCertificate, certificateFilename); err != nil {
+		return err
 	}
+	if err := CheckCertificateRevocation(certificateX509, certificateFilename); err != nil {
+		return err
+	}
+	ValidateCertificateChain(certificateX509, certificateFilename)
 
+	now := time.Now()
 	expireAfterHours := gostCertificate.NotAfter.Sub(now).Hours()
 	if expireAfterHours > 0 {
 		expireAfterDays := int(expireAfterHours / 24)
@@ -277,16 +520,35 @@ func InstallESignature(rootContainersFolder string, installParams *ESignatureIns
 		}
 	}
 
+	containerPin := ""
+	if installParams.ContainerPin != nil {
+		containerPin, err = ResolveSecret(*installParams.ContainerPin, containerFilename)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Не удалось получить PIN-код контейнера[%s]: %s", containerFilename, err))
+			return err
+		}
+	}
+
+	if installParams.Store != "" {
+		exists, err := IsReaderExists(installParams.Store)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Не удалось проверить наличие считывателя[%s]", installParams.Store))
+		} else if !exists {
+			slog.Error(fmt.Sprintf("Считыватель[%s] не найден, контейнер[%s] не установлен", installParams.Store, containerFilename))
+			return ErrReaderNotFound
+		}
+	}
+
 	var container *cades.Container
-	if filepath.Ext(installParams.ContainerPath) != ".pfx" {
-		if IsPrivateKeyMalformed(installParams.ContainerPath) {
-			slog.Error(fmt.Sprintf("Контейнер[%s] поврежден (Владелец: %s)", containerFilename, containerSubject.Normal))
+	if !IsPfxFile(installParams.ContainerPath) {
+		if err := ValidateContainer(installParams.ContainerPath, certificateX509); err != nil {
+			slog.Error(fmt.Sprintf("Контейнер[%s] поврежден: %s (Владелец: %s)", containerFilename, err, containerSubject))
 			return os.ErrInvalid
 		}
 
-		container, err = InstallContainerFromFolder(installParams.ContainerPath, rootContainersFolder, "")
+		container, err = InstallContainerFromFolder(installParams.ContainerPath, rootContainersFolder, installParams.Store, "", containerPin)
 		if err != nil {
-			slog.Error(fmt.Sprintf("Не удалось установить контейнер[%s] (Владелец: %s)", containerFilename, containerSubject.Normal))
+			slog.Error(fmt.Sprintf("Не удалось установить контейнер[%s] (Владелец: %s)", containerFilename, containerSubject))
 			return err
 		} else {
 			slog.Debug(fmt.Sprintf("Контейнер[%s] установлен, имя[%s]", containerFilename, container.ContainerName))
@@ -297,13 +559,14 @@ func InstallESignature(rootContainersFolder string, installParams *ESignatureIns
 			pfxName := fmt.Sprintf("%s-temp.pfx", id.String())
 			pfxPath := filepath.Join(rootContainersFolder, pfxName)
 
-			ok, _ := LinkCertWithContainer(installParams.CertificatePath, container.ContainerName)
+			ok, _ := LinkCertWithContainer(installParams.CertificatePath, container.ContainerName, containerPin)
 			if ok {
-				pfxPath, err = ExportContainerToPfxByThumbprint(container, thumbprint, pfxPath, "")
+				// Временный pfx защищается PIN-кодом контейнера
+				pfxPath, err = ExportContainerToPfxByThumbprint(container, thumbprint, pfxPath, containerPin)
 				if err == nil {
 					containerFilename = pfxName
-					emptyPassword := ""
-					installParams.PfxPassword = &emptyPassword
+					pfxPassword := SECRET_PLAIN_PREFIX + containerPin
+					installParams.PfxPassword = &pfxPassword
 					installParams.ContainerPath = pfxPath
 					defer os.Remove(pfxPath)
 
@@ -314,10 +577,25 @@ func InstallESignature(rootContainersFolder string, installParams *ESignatureIns
 		}
 	}
 
-	if filepath.Ext(installParams.ContainerPath) == ".pfx" {
-		pfxResult, err := InstallContainerFromPfx(installParams.ContainerPath, *installParams.PfxPassword, *installParams.Exportable)
+	if IsPfxFile(installParams.ContainerPath) {
+		// certmgr защищает контейнер из pfx паролем pfx, поэтому без отдельного пароля используется PIN-код и наоборот
+		pfxPassword := containerPin
+		if installParams.PfxPassword != nil {
+			pfxPassword, err = ResolveSecret(*installParams.PfxPassword, containerFilename)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Не удалось получить пароль от pfx файла[%s]: %s", containerFilename, err))
+				return err
+			}
+
+			if installParams.ContainerPin == nil {
+				containerPin = pfxPassword
+			}
+		}
+
+		exportable := installParams.Exportable != nil && *installParams.Exportable
+		pfxResult, err := InstallContainerFromPfx(installParams.ContainerPath, pfxPassword, exportable)
 		if err != nil {
-			slog.Error(fmt.Sprintf("Не удалось установить контейнер из pfx файла[%s] (Владелец: %s)", containerFilename, containerSubject.Normal))
+			slog.Error(fmt.Sprintf("Не удалось установить контейнер из pfx файла[%s] (Владелец: %s)", containerFilename, containerSubject))
 			if strings.Contains(pfxResult.Output, "unrecognized option `-pfx") {
 				slog.Warn("Установка контейнеров из pfx файлов доступна с версии КриптоПро CSP 4.0.9944 R3 (Xenocrates) от 22.02.2018.")
 			}
@@ -327,29 +605,63 @@ func InstallESignature(rootContainersFolder string, installParams *ESignatureIns
 		if pfxResult.Container.ContainerName == "" && pfxResult.Container.UniqueContainerName == "" {
 			slog.Error(fmt.Sprintf(
 				"Не удалось установить контейнер из pfx файла[%s], отсутствует закрытый ключ (Владелец: %s)",
-				containerFilename, containerSubject.Normal,
+				containerFilename, containerSubject,
 			))
 			return err
 		}
 
 		slog.Debug(fmt.Sprintf("Контейнер установлен из Pfx[%s], Имя контейнера:'%s'", containerFilename, pfxResult.Container.ContainerName))
 		container = &pfxResult.Container
+
+		if err := ConfirmPfxKey(pfxResult, certificateX509); err != nil {
+			slog.Error(fmt.Sprintf("Ключ pfx файла[%s] не соответствует сертификату[%s], контейнер удален", containerFilename, certificateFilename))
+			DeleteContainer(container)
+			return err
+		}
+
+		// certmgr устанавливает pfx в считыватель по умолчанию
+		if installParams.Store != "" && ContainerReader(container) != installParams.Store {
+			movedContainer, err := MoveContainer(container, installParams.Store, containerPin)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Не удалось перенести контейнер[%s] в считыватель[%s]", container.ContainerName, installParams.Store))
+				DeleteContainer(container)
+				return err
+			}
+			slog.Debug(fmt.Sprintf("Контейнер[%s] перенесен в [%s]", container.ContainerName, movedContainer.ContainerName))
+			container = movedContainer
+		}
 	}
 
+	var newContainerName ContainerName
 	if installParams.ContainerName != "" {
+		newContainerName = FormatNewName(installParams.ContainerName, nameCertificate)
+		if newContainerName.Normal == "" {
+			slog.Warn(fmt.Sprintf("Имя контейнера по шаблону[%s] пустое, контейнер[%s] не переименован", installParams.ContainerName, container.ContainerName))
+		}
+	}
+
+	// Контейнеры с тем же именем при политике overwrite удаляются только после установки подписи
+	targetContainerName := newContainerName
+	var replacedContainers []cades.Container
+	if newContainerName.Normal != "" {
 		oldContainerName := container.ContainerName
-		newContainerName := FormatNewName(installParams.ContainerName, gostCertificate)
+		newContainerName, replacedContainers, err = ResolveNameCollision(container, newContainerName, thumbprint, installParams.NameCollisionPolicy)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Контейнер с именем[%s] уже существует, подпись[%s] пропущена: %s", newContainerName.Normal, containerFilename, err))
+			DeleteContainer(container)
+			return err
+		}
 
-		newContainer, err := RenameContainer(container, newContainerName)
+		newContainer, err := RenameContainer(container, newContainerName, containerPin)
 		if errors.Is(err, cades.ErrContainerNotExportable) {
 			slog.Warn(fmt.Sprintf("Контейнер[%s] не экспортируемый", container.ContainerName))
-			if filepath.Ext(installParams.ContainerPath) == ".pfx" {
+			if IsPfxFile(installParams.ContainerPath) {
 				DeleteContainer(container)
 			}
 			return err
 		} else if err != nil {
 			slog.Error(fmt.Sprintf("Не удалось переименовать контейнер [%s] -> [%s]", container.ContainerName, newContainerName.Normal))
-			if filepath.Ext(installParams.ContainerPath) == ".pfx" {
+			if IsPfxFile(installParams.ContainerPath) {
 				DeleteContainer(container)
 			}
 			return err
@@ -359,7 +671,26 @@ func InstallESignature(rootContainersFolder string, installParams *ESignatureIns
 		}
 	}
 
-	isCertLink, err := LinkCertWithContainer(installParams.CertificatePath, container.ContainerName)
+	if installParams.ClearContainerPin != nil && *installParams.ClearContainerPin {
+		if err := ChangeContainerPin(container, containerPin, ""); err != nil {
+			slog.Warn(fmt.Sprintf("Не удалось снять PIN-код с контейнера[%s]", container.ContainerName))
+		} else {
+			containerPin = ""
+			slog.Info(fmt.Sprintf("PIN-код контейнера[%s] снят", container.ContainerName))
+		}
+	} else if installParams.NewContainerPin != nil && *installParams.NewContainerPin != "" {
+		newPin, err := ResolveSecret(*installParams.NewContainerPin, container.ContainerName)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Не удалось получить новый PIN-код контейнера[%s]: %s", container.ContainerName, err))
+		} else if err := ChangeContainerPin(container, containerPin, newPin); err != nil {
+			slog.Warn(fmt.Sprintf("Не удалось установить PIN-код контейнера[%s]", container.ContainerName))
+		} else {
+			containerPin = newPin
+			slog.Info(fmt.Sprintf("PIN-код контейнера[%s] изменен", container.ContainerName))
+		}
+	}
+
+	isCertLink, err := linkContainerCertificates(container, installParams, containerPin)
 	if err != nil || !isCertLink {
 		DeleteContainer(container)
 		slog.Error(fmt.Sprintf(
@@ -367,8 +698,43 @@ func InstallESignature(rootContainersFolder string, installParams *ESignatureIns
 			certificateFilename, container.UniqueContainerName,
 		))
 		return err
-	} else {
-		slog.Info(fmt.Sprintf("Установлен контейнер[%s]", container.ContainerName))
 	}
+
+	if len(replacedContainers) > 0 {
+		replacedContainer, err := ReplaceContainers(container, replacedContainers, targetContainerName, containerPin)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Не удалось заменить контейнер[%s], подпись установлена в контейнер[%s]: %s", targetContainerName.Normal, container.ContainerName, err))
+		} else {
+			container = replacedContainer
+			if ok, _ := linkContainerCertificates(container, installParams, containerPin); !ok {
+				slog.Warn(fmt.Sprintf("Не удалось связать сертификат[%s] с контейнером[%s]", certificateFilename, container.ContainerName))
+			}
+		}
+	}
+
+	slog.Info(fmt.Sprintf("Установлен контейнер[%s]", container.ContainerName))
 	return nil
 }
+
+// linkContainerCertificates устанавливает сертификат подписи в контейнер, остальные сертификаты на том же ключе
+// устанавливаются первыми, чтобы в контейнере остался выбранный
+func linkContainerCertificates(container *cades.Container, installParams *ESignatureInstallParams, containerPin string) (bool, error) {
+	if installParams.InstallAllCertificates != nil && *installParams.InstallAllCertificates {
+		for _, extraCertificatePath := range installParams.ExtraCertificates {
+			extraCertificatePath, err := NormalizeCertificateFile(extraCertificatePath)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Не удалось прочитать сертификат[%s]", extraCertificatePath))
+				continue
+			}
+
+			ok, _ := LinkCertWithContainer(extraCertificatePath, container.ContainerName, containerPin)
+			if ok {
+				slog.Info(fmt.Sprintf("Дополнительный сертификат[%s] установлен в контейнер[%s]", filepath.Base(extraCertificatePath), container.ContainerName))
+			} else {
+				slog.Warn(fmt.Sprintf("Не удалось установить дополнительный сертификат[%s] в контейнер[%s]", filepath.Base(extraCertificatePath), container.ContainerName))
+			}
+		}
+	}
+
+	return LinkCertWithContainer(installParams.CertificatePath, container.ContainerName, containerPin)
+}
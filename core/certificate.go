@@ -0,0 +1,192 @@
+package core
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CERTIFICATE_ENCODING_DER    = "der"
+	CERTIFICATE_ENCODING_PEM    = "pem"
+	CERTIFICATE_ENCODING_BASE64 = "base64"
+
+	// Сертификаты и p7b цепочки занимают несколько килобайт, файлы больше не разбираются
+	MAX_CERTIFICATE_FILE_SIZE = 1 << 20
+	// По началу файла отбрасываются документы, изображения и другие файлы, чтобы не читать их целиком
+	CERTIFICATE_SNIFF_SIZE = 4096
+)
+
+var (
+	oidSignedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+	ErrNotCertificate = errors.New("file is not a certificate")
+)
+
+// DecodeCertificateData приводит DER, PEM или base64 (без заголовков) к DER
+func DecodeCertificateData(data []byte) ([]byte, string) {
+	if block, _ := pem.Decode(data); block != nil {
+		return block.Bytes, CERTIFICATE_ENCODING_PEM
+	}
+
+	if len(data) > 0 && data[0] == 0x30 {
+		return data, CERTIFICATE_ENCODING_DER
+	}
+
+	text := strings.Join(strings.Fields(string(data)), "")
+	if decoded, err := base64.StdEncoding.DecodeString(text); err == nil && len(decoded) > 0 && decoded[0] == 0x30 {
+		return decoded, CERTIFICATE_ENCODING_BASE64
+	}
+	return data, CERTIFICATE_ENCODING_DER
+}
+
+func LoadCertificateData(data []byte) (*x509.Certificate, error) {
+	raw, _ := DecodeCertificateData(data)
+	return x509.ParseCertificate(raw)
+}
+
+// hasCertificateSignature проверяет начало файла: DER (ASN.1 SEQUENCE), base64 без заголовков
+// (SEQUENCE с длиной от 128 байт кодируется как "MI") или PEM, перед которым может быть текст
+func hasCertificateSignature(head []byte) bool {
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	return bytes.HasPrefix(trimmed, []byte{0x30}) ||
+		bytes.HasPrefix(trimmed, []byte("MI")) ||
+		bytes.Contains(head, []byte("-----BEGIN"))
+}
+
+// readCertificateFile читает файл, если он похож на сертификат, иначе возвращает ErrNotCertificate.
+// Ошибки чтения возвращаются как есть
+func readCertificateFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() > MAX_CERTIFICATE_FILE_SIZE {
+		return nil, ErrNotCertificate
+	}
+
+	head := make([]byte, CERTIFICATE_SNIFF_SIZE)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+
+	if !hasCertificateSignature(head[:n]) {
+		return nil, ErrNotCertificate
+	}
+
+	rest, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return append(head[:n], rest...), nil
+}
+
+// LoadCertificateFile определяет сертификат по содержимому файла, а не по расширению
+func LoadCertificateFile(path string) (*x509.Certificate, error) {
+	data, err := readCertificateFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := LoadCertificateData(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotCertificate, err)
+	}
+	return certificate, nil
+}
+
+// IsPkcs7File проверяет, что файл содержит PKCS#7 SignedData (p7b цепочка сертификатов)
+func IsPkcs7File(path string) bool {
+	data, err := readCertificateFile(path)
+	if err != nil {
+		return false
+	}
+
+	raw, _ := DecodeCertificateData(data)
+	var contentInfo pfxContentInfo
+	if _, err := asn1.Unmarshal(raw, &contentInfo); err != nil {
+		return false
+	}
+	return contentInfo.ContentType.Equal(oidSignedDataContentType)
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"tag:0,optional"`
+	Crls             asn1.RawValue `asn1:"tag:1,optional"`
+	SignerInfos      asn1.RawValue
+}
+
+// ExtractCertificatesFromPkcs7 возвращает сертификаты из p7b цепочки (PKCS#7 SignedData)
+func ExtractCertificatesFromPkcs7(data []byte) ([]*x509.Certificate, error) {
+	raw, _ := DecodeCertificateData(data)
+
+	var contentInfo pfxContentInfo
+	if _, err := asn1.Unmarshal(raw, &contentInfo); err != nil {
+		return nil, err
+	}
+	if !contentInfo.ContentType.Equal(oidSignedDataContentType) {
+		return nil, ErrNotCertificate
+	}
+
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificates(signedData.Certificates.Bytes)
+}
+
+// LoadPkcs7File возвращает сертификаты из p7b файла
+func LoadPkcs7File(path string) ([]*x509.Certificate, error) {
+	data, err := readCertificateFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ExtractCertificatesFromPkcs7(data)
+}
+
+// NormalizeCertificateFile возвращает путь до сертификата, понятного утилитам КриптоПро.
+// Сертификаты в base64 без заголовков сохраняются во временную директорию в DER.
+func NormalizeCertificateFile(path string) (string, error) {
+	data, err := readCertificateFile(path)
+	if err != nil {
+		return path, err
+	}
+
+	raw, encoding := DecodeCertificateData(data)
+	if encoding != CERTIFICATE_ENCODING_BASE64 {
+		return path, nil
+	}
+
+	certificate, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return path, err
+	}
+	return SaveTempCertificate(certificate, filepath.Base(path))
+}
+
+func isCertificateCandidate(name string) bool {
+	lowerName := strings.ToLower(name)
+	if strings.HasSuffix(lowerName, ".key") || IsPfxFile(name) || IsZipArchive(name) || isManifestFile(name) {
+		return false
+	}
+	return !strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCertificateFile(t *testing.T) {
+	certificate := newTestCertificate(t, &x509.Certificate{})
+	pemData := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}))
+
+	tests := []struct {
+		name        string
+		content     string
+		certificate bool
+	}{
+		{"DER", string(certificate.Raw), true},
+		{"PEM", pemData, true},
+		{"PEM после текста", "Bag Attributes\n    friendlyName: test\n" + pemData, true},
+		{"base64 без заголовков", base64.StdEncoding.EncodeToString(certificate.Raw), true},
+		{"base64 с переносом строки", "\r\n" + base64.StdEncoding.EncodeToString(certificate.Raw), true},
+		{"текстовый файл", "Инструкция по установке подписи", false},
+		{"изображение", "\x89PNG\r\n\x1a\n" + string(bytes.Repeat([]byte{0}, 8192)), false},
+		{"пустой файл", "", false},
+		{"слишком большой файл", string(bytes.Repeat([]byte{0x30}, MAX_CERTIFICATE_FILE_SIZE+1)), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.cer")
+			writeTestFile(t, path, test.content)
+
+			data, err := readCertificateFile(path)
+			if !test.certificate {
+				if !errors.Is(err, ErrNotCertificate) {
+					t.Errorf("readCertificateFile() error = %v, want ErrNotCertificate", err)
+				}
+				return
+			}
+
+			if err != nil || string(data) != test.content {
+				t.Fatalf("readCertificateFile() = %d bytes, %v", len(data), err)
+			}
+
+			loaded, err := LoadCertificateFile(path)
+			if err != nil || !loaded.Equal(certificate) {
+				t.Errorf("LoadCertificateFile() error = %v", err)
+			}
+		})
+	}
+
+	if _, err := readCertificateFile(t.TempDir()); err == nil || errors.Is(err, ErrNotCertificate) {
+		t.Errorf("readCertificateFile() for directory error = %v, want read error", err)
+	}
+}
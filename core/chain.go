@@ -0,0 +1,337 @@
+package core
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+	"golang.org/x/exp/slog"
+)
+
+// Цепочка длиннее считается зацикленной (например, из-за кросс-сертификатов)
+const MAX_CHAIN_DEPTH = 10
+
+// rootCertificate - сертификат из папки certs/root, для p7b Path указывает на файл цепочки
+type rootCertificate struct {
+	Path        string
+	Certificate *x509.Certificate
+}
+
+// ChainLink - издатель в цепочке сертификата, Certificate пустой для сертификата из хранилища
+type ChainLink struct {
+	Certificate *x509.Certificate
+	Path        string
+	Subject     string
+	NotAfter    time.Time
+	Installed   bool
+}
+
+// installedCertificate - сертификат из хранилища, Certificate выгружается из хранилища при проверке издателя
+type installedCertificate struct {
+	Info        cades.GostCertificate
+	Store       string
+	Certificate *x509.Certificate
+	loaded      bool
+}
+
+type CertificateChain struct {
+	Links    []*ChainLink
+	Complete bool
+	Problems []string
+}
+
+var (
+	chainRootFolder      string
+	chainInstallNeeded   bool
+	chainLocal           []*rootCertificate
+	chainLocalLoaded     bool
+	chainInstalled       []*installedCertificate
+	chainInstalledLoaded bool
+)
+
+// SetChainValidation включает проверку цепочки по папке root и установленным корневым сертификатам.
+// installNeeded - устанавливать из папки только сертификаты, нужные для цепочки.
+func SetChainValidation(rootFolder string, installNeeded bool) {
+	chainRootFolder = rootFolder
+	chainInstallNeeded = installNeeded
+	chainLocalLoaded = false
+	chainInstalledLoaded = false
+}
+
+func loadRootFolderCertificates(rootFolder string) []*rootCertificate {
+	var result []*rootCertificate
+
+	folderEntity, err := os.ReadDir(rootFolder)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Debug(fmt.Sprintf("Cant get entities from root folder[%s], error: %s", rootFolder, err.Error()))
+		}
+		return result
+	}
+
+	for _, entity := range folderEntity {
+		if entity.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(rootFolder, entity.Name())
+		if err := CheckManifestIntegrity(path); err != nil {
+			continue
+		}
+
+		certificates, err := loadRootFile(path)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("File[%s] is not a certificate, error: %s", path, err.Error()))
+			continue
+		}
+
+		for _, c := range certificates {
+			result = append(result, &rootCertificate{Path: path, Certificate: c})
+		}
+	}
+	return result
+}
+
+func loadInstalledChainCertificates() []*installedCertificate {
+	var result []*installedCertificate
+
+	m := cades.CadesManager{}
+	for _, store := range []string{CERTIFICATE_STORE_ROOT, CERTIFICATE_STORE_CA} {
+		certificates, err := m.GetCertificatesInfo("", store)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("Cant get certificates from store[%s]: %s", store, err))
+			continue
+		}
+
+		for _, c := range certificates {
+			result = append(result, &installedCertificate{Info: c, Store: store})
+		}
+	}
+	return result
+}
+
+// exportInstalledCertificate выгружает сертификат из хранилища во временную директорию
+func exportInstalledCertificate(thumbprint string, store string) (*x509.Certificate, error) {
+	temp, err := GetTempFolder()
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(temp, fmt.Sprintf("%s.cer", thumbprint))
+	defer os.Remove(path)
+
+	output, err := cades.NewCertManagerProcess("-export", "-cert", "-thumbprint", thumbprint, "-store", store, "-dest", path)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Certmgr log: %s", output))
+		return nil, err
+	}
+	return LoadCertificateFile(path)
+}
+
+// load возвращает сертификат из хранилища, выгрузка выполняется один раз
+func (c *installedCertificate) load() *x509.Certificate {
+	if c.Certificate != nil || c.loaded {
+		return c.Certificate
+	}
+
+	c.loaded = true
+	certificate, err := exportInstalledCertificate(c.Info.Thumbprint, c.Store)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant export certificate[%s] from store[%s]: %s", c.Info.Thumbprint, c.Store, err))
+		return nil
+	}
+	c.Certificate = certificate
+	return certificate
+}
+
+// isIssuedBy сравнивает имя издателя и идентификатор ключа, подпись ГОСТ средствами x509 не проверяется
+func isIssuedBy(certificate *x509.Certificate, issuer *x509.Certificate) bool {
+	if !bytes.Equal(certificate.RawIssuer, issuer.RawSubject) {
+		return false
+	}
+
+	if len(certificate.AuthorityKeyId) > 0 && len(issuer.SubjectKeyId) > 0 {
+		return bytes.Equal(certificate.AuthorityKeyId, issuer.SubjectKeyId)
+	}
+	return true
+}
+
+func isSelfSigned(certificate *x509.Certificate) bool {
+	return isIssuedBy(certificate, certificate)
+}
+
+// distinguishedNameMap приводит имя к виду cades.GostCertificate для сравнения с сертификатами из хранилища
+func distinguishedNameMap(names []pkix.AttributeTypeAndValue) map[string]string {
+	result := make(map[string]string)
+	for _, v := range names {
+		name, ok := cades.SubjectAndIssuerNames[v.Type.String()]
+		if !ok {
+			name = v.Type.String()
+		}
+		result[name] = fmt.Sprint(v.Value)
+	}
+	return result
+}
+
+func isSameDistinguishedName(a map[string]string, b map[string]string) bool {
+	if a["common_name"] == "" || !strings.EqualFold(a["common_name"], b["common_name"]) {
+		return false
+	}
+	return a["organization_name"] == "" || b["organization_name"] == "" || strings.EqualFold(a["organization_name"], b["organization_name"])
+}
+
+// findLocalIssuer выбирает издателя из папки root, действующий сертификат с поздним сроком приоритетнее
+func findLocalIssuer(certificate *x509.Certificate, local []*rootCertificate, now time.Time) *rootCertificate {
+	var result *rootCertificate
+	for _, c := range local {
+		if c.Certificate == certificate || !isIssuedBy(certificate, c.Certificate) {
+			continue
+		}
+
+		if result == nil {
+			result = c
+			continue
+		}
+
+		valid := now.Before(c.Certificate.NotAfter)
+		resultValid := now.Before(result.Certificate.NotAfter)
+		if valid && !resultValid || valid == resultValid && c.Certificate.NotAfter.After(result.Certificate.NotAfter) {
+			result = c
+		}
+	}
+	return result
+}
+
+// findInstalledIssuer ищет издателя в хранилище: кандидаты отбираются по CN и O, издатель подтверждается
+// по имени и идентификатору ключа (AKI/SKI) сертификата, выгруженного из хранилища
+func findInstalledIssuer(certificate *x509.Certificate, installed []*installedCertificate) *installedCertificate {
+	issuer := distinguishedNameMap(certificate.Issuer.Names)
+	for _, c := range installed {
+		if !isSameDistinguishedName(issuer, c.Info.Subject) {
+			continue
+		}
+
+		if candidate := c.load(); candidate != nil && isIssuedBy(certificate, candidate) {
+			return c
+		}
+	}
+	return nil
+}
+
+// BuildCertificateChain строит цепочку издателей сертификата по сертификатам из папки root,
+// цепочка завершается самоподписанным сертификатом или сертификатом из хранилища
+func BuildCertificateChain(certificate *x509.Certificate, local []*rootCertificate, installed []*installedCertificate, now time.Time) *CertificateChain {
+	chain := &CertificateChain{}
+	current := certificate
+
+	for depth := 0; depth < MAX_CHAIN_DEPTH; depth++ {
+		if isSelfSigned(current) {
+			chain.Complete = true
+			return chain
+		}
+
+		if issuer := findLocalIssuer(current, local, now); issuer != nil {
+			link := &ChainLink{
+				Certificate: issuer.Certificate,
+				Path:        issuer.Path,
+				Subject:     issuer.Certificate.Subject.CommonName,
+				NotAfter:    issuer.Certificate.NotAfter,
+			}
+			chain.Links = append(chain.Links, link)
+			current = issuer.Certificate
+		} else if issuer := findInstalledIssuer(current, installed); issuer != nil {
+			link := &ChainLink{
+				Subject:   issuer.Info.Subject["common_name"],
+				NotAfter:  issuer.Info.NotAfter,
+				Installed: true,
+			}
+			chain.Links = append(chain.Links, link)
+			chain.Complete = true
+		} else {
+			chain.Problems = append(chain.Problems, fmt.Sprintf("не найден издатель %s", current.Issuer.CommonName))
+			return chain
+		}
+
+		link := chain.Links[len(chain.Links)-1]
+		if now.After(link.NotAfter) {
+			chain.Problems = append(chain.Problems, fmt.Sprintf(
+				"истек срок действия издателя %s (был действителен до %s)",
+				link.Subject, link.NotAfter.Format("02.01.2006 15:04:05"),
+			))
+		}
+
+		if chain.Complete {
+			return chain
+		}
+	}
+
+	chain.Problems = append(chain.Problems, fmt.Sprintf("цепочка длиннее %d сертификатов", MAX_CHAIN_DEPTH))
+	return chain
+}
+
+func installChainCertificates(chain *CertificateChain) {
+	for _, link := range chain.Links {
+		if link.Certificate == nil || link.Installed {
+			continue
+		}
+
+		store := CertificateStore(link.Certificate)
+		thumbprint := cades.GetThumbprint(link.Certificate)
+		if exists, _ := IsCertificateExists(thumbprint, store); exists {
+			link.Installed = true
+			continue
+		}
+
+		if err := installCertificateToStore(link.Path, link.Certificate, store); err != nil {
+			slog.Error(fmt.Sprintf("Не удалось установить сертификат цепочки[%s]: %s", link.Subject, err))
+			continue
+		}
+
+		link.Installed = true
+		slog.Info(fmt.Sprintf("Сертификат цепочки[%s] установлен из %s", link.Subject, filepath.Base(link.Path)))
+	}
+}
+
+// ValidateCertificateChain проверяет цепочку сертификата перед установкой и выводит предупреждения
+// об отсутствующих и истекших издателях. Возвращает nil, если проверка цепочки выключена.
+func ValidateCertificateChain(certificate *x509.Certificate, certificateFilename string) *CertificateChain {
+	if chainRootFolder == "" {
+		return nil
+	}
+
+	if !chainLocalLoaded {
+		chainLocal = loadRootFolderCertificates(chainRootFolder)
+		chainLocalLoaded = true
+	}
+
+	chain := BuildCertificateChain(certificate, chainLocal, nil, time.Now())
+	if !chain.Complete {
+		if !chainInstalledLoaded {
+			chainInstalled = loadInstalledChainCertificates()
+			chainInstalledLoaded = true
+		}
+		chain = BuildCertificateChain(certificate, chainLocal, chainInstalled, time.Now())
+	}
+
+	for _, problem := range chain.Problems {
+		slog.Warn(fmt.Sprintf("Цепочка сертификата[%s]: %s", certificateFilename, problem))
+	}
+
+	if chainInstallNeeded {
+		installChainCertificates(chain)
+	}
+
+	subjects := make([]string, len(chain.Links))
+	for i, link := range chain.Links {
+		subjects[i] = link.Subject
+	}
+	slog.Debug(fmt.Sprintf("Certificate[%s] chain: %s, complete: %v", certificateFilename, strings.Join(subjects, " -> "), chain.Complete))
+	return chain
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+)
+
+var testChainNow = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type testChainCertificate struct {
+	Certificate *x509.Certificate
+	Key         *ecdsa.PrivateKey
+}
+
+// newTestChainCertificate создает сертификат, подписанный parent (самоподписанный, если parent пустой)
+func newTestChainCertificate(t *testing.T, commonName string, isCA bool, parent *testChainCertificate) *testChainCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"Тестовый УЦ"}},
+		NotBefore:             testChainNow.AddDate(-1, 0, 0),
+		NotAfter:              testChainNow.AddDate(1, 0, 0),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+
+	issuer, issuerKey := template, key
+	if parent != nil {
+		issuer, issuerKey = parent.Certificate, parent.Key
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testChainCertificate{Certificate: certificate, Key: key}
+}
+
+func newTestInstalledCertificate(certificate *x509.Certificate) *installedCertificate {
+	return &installedCertificate{
+		Info: cades.GostCertificate{
+			Subject:  distinguishedNameMap(certificate.Subject.Names),
+			NotAfter: certificate.NotAfter,
+		},
+		Certificate: certificate,
+	}
+}
+
+func TestBuildCertificateChain(t *testing.T) {
+	root := newTestChainCertificate(t, "Корневой УЦ", true, nil)
+	intermediate := newTestChainCertificate(t, "Промежуточный УЦ", true, root)
+	leaf := newTestChainCertificate(t, "Иванов Иван Иванович", false, intermediate)
+	// Другой УЦ с тем же CN и O, но другим ключом
+	impostor := newTestChainCertificate(t, "Промежуточный УЦ", true, root)
+
+	local := []*rootCertificate{
+		{Path: "root.cer", Certificate: root.Certificate},
+		{Path: "intermediate.cer", Certificate: intermediate.Certificate},
+	}
+
+	tests := []struct {
+		name      string
+		local     []*rootCertificate
+		installed []*installedCertificate
+		links     int
+		complete  bool
+	}{
+		{"цепочка из папки root", local, nil, 2, true},
+		{"издатель в хранилище", nil, []*installedCertificate{newTestInstalledCertificate(intermediate.Certificate)}, 1, true},
+		{"издатель в хранилище с тем же именем и другим ключом", nil, []*installedCertificate{newTestInstalledCertificate(impostor.Certificate)}, 0, false},
+		{"издатель из хранилища не выгружен", nil, []*installedCertificate{{
+			Info:   newTestInstalledCertificate(intermediate.Certificate).Info,
+			loaded: true,
+		}}, 0, false},
+		{"корневой в хранилище", local[1:], []*installedCertificate{
+			newTestInstalledCertificate(impostor.Certificate),
+			newTestInstalledCertificate(root.Certificate),
+		}, 2, true},
+		{"нет издателя", nil, nil, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := BuildCertificateChain(leaf.Certificate, test.local, test.installed, testChainNow)
+			if chain.Complete != test.complete || len(chain.Links) != test.links {
+				t.Errorf("BuildCertificateChain() complete = %t, links = %d, want %t, %d (%v)",
+					chain.Complete, len(chain.Links), test.complete, test.links, chain.Problems)
+			}
+
+			if !test.complete && len(chain.Problems) == 0 {
+				t.Error("BuildCertificateChain() incomplete chain without problems")
+			}
+		})
+	}
+}
+
+func TestBuildCertificateChainExpiredIssuer(t *testing.T) {
+	root := newTestChainCertificate(t, "Корневой УЦ", true, nil)
+	leaf := newTestChainCertificate(t, "Иванов Иван Иванович", false, root)
+
+	chain := BuildCertificateChain(leaf.Certificate, []*rootCertificate{{Certificate: root.Certificate}}, nil, testChainNow.AddDate(2, 0, 0))
+	if !chain.Complete || len(chain.Problems) != 1 {
+		t.Errorf("BuildCertificateChain() complete = %t, problems = %v, want expired issuer", chain.Complete, chain.Problems)
+	}
+}
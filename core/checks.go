@@ -0,0 +1,230 @@
+package core
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+	"golang.org/x/exp/slog"
+)
+
+// Действия при непрохождении проверки сертификата перед установкой:
+//
+//	warn - предупреждение, подпись устанавливается (по умолчанию)
+//	skip - подпись пропускается
+//	fail - установка останавливается
+const (
+	CHECK_ACTION_WARN = "warn"
+	CHECK_ACTION_SKIP = "skip"
+	CHECK_ACTION_FAIL = "fail"
+)
+
+var (
+	ErrCertificateCheckSkip = errors.New("certificate skipped by pre-install check")
+	ErrCertificateCheckFail = errors.New("installation stopped by pre-install check")
+	ErrCheckAction          = errors.New("unknown certificate check action")
+)
+
+var (
+	// ГОСТ Р 34.10-2001 и подпись ГОСТ Р 34.11-94/34.10-2001 больше не принимаются
+	deprecatedKeyAlgorithms = map[string]string{
+		"1.2.643.2.2.19": "ГОСТ Р 34.10-2001",
+	}
+	deprecatedSignatureAlgorithms = map[string]string{
+		"1.2.643.2.2.3": "ГОСТ Р 34.11-94/34.10-2001",
+	}
+
+	oidExtensionKeyUsage         = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtensionExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+	// Проверка подлинности клиента
+	defaultRequiredExtendedKeyUsage = []string{"1.3.6.1.5.5.7.3.2"}
+)
+
+var certificateChecks SettingsChecksBlock
+
+// SetCertificateChecks проверяет и сохраняет действия проверок, неизвестное действие - ошибка
+func SetCertificateChecks(checks SettingsChecksBlock) error {
+	actions := []struct {
+		name   string
+		action **string
+	}{
+		{"keyAlgorithm", &checks.KeyAlgorithm},
+		{"signatureAlgorithm", &checks.SignatureAlgorithm},
+		{"keyUsage", &checks.KeyUsage},
+		{"extendedKeyUsage", &checks.ExtendedKeyUsage},
+		{"expired", &checks.Expired},
+		{"notYetValid", &checks.NotYetValid},
+		{"revoked", &checks.Revoked},
+	}
+
+	for _, a := range actions {
+		if *a.action == nil {
+			continue
+		}
+
+		action, err := ParseCheckAction(**a.action)
+		if err != nil {
+			return fmt.Errorf("checks.%s: %w", a.name, err)
+		}
+		*a.action = &action
+	}
+
+	certificateChecks = checks
+	return nil
+}
+
+// ParseCheckAction проверяет действие проверки, регистр букв не учитывается, пустое значение означает warn
+func ParseCheckAction(action string) (string, error) {
+	switch value := strings.ToLower(strings.TrimSpace(action)); value {
+	case "":
+		return CHECK_ACTION_WARN, nil
+	case CHECK_ACTION_WARN, CHECK_ACTION_SKIP, CHECK_ACTION_FAIL:
+		return value, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrCheckAction, action)
+	}
+}
+
+type certificateCheck struct {
+	Action  *string
+	Failure string
+}
+
+func getCheckAction(action *string) string {
+	if action == nil || *action == "" {
+		return CHECK_ACTION_WARN
+	}
+	return *action
+}
+
+// signatureAlgorithmOid возвращает OID алгоритма подписи, x509 не разбирает ГОСТ алгоритмы
+func signatureAlgorithmOid(certificate *x509.Certificate) string {
+	var raw struct {
+		TBSCertificate     asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		SignatureValue     asn1.BitString
+	}
+
+	if _, err := asn1.Unmarshal(certificate.Raw, &raw); err != nil {
+		return ""
+	}
+	return raw.SignatureAlgorithm.Algorithm.String()
+}
+
+func hasExtension(certificate *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, extension := range certificate.Extensions {
+		if extension.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+func extendedKeyUsageOids(certificate *x509.Certificate) ([]string, bool) {
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oidExtensionExtendedKeyUsage) {
+			continue
+		}
+
+		var oids []asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(extension.Value, &oids); err != nil {
+			return nil, true
+		}
+
+		result := make([]string, len(oids))
+		for i, oid := range oids {
+			result[i] = oid.String()
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func collectCertificateChecks(certificate *x509.Certificate, gostCertificate *cades.GostCertificate, now time.Time) []certificateCheck {
+	checks := certificateChecks
+	var failed []certificateCheck
+
+	if name, ok := deprecatedKeyAlgorithms[gostCertificate.Algorithm.OID]; ok {
+		failed = append(failed, certificateCheck{checks.KeyAlgorithm, fmt.Sprintf("устаревший алгоритм ключа %s", name)})
+	}
+
+	if name, ok := deprecatedSignatureAlgorithms[signatureAlgorithmOid(certificate)]; ok {
+		failed = append(failed, certificateCheck{checks.SignatureAlgorithm, fmt.Sprintf("устаревший алгоритм подписи %s", name)})
+	}
+
+	// Как и для EKU, сертификат без расширения KeyUsage не ограничен в назначении ключа
+	if hasExtension(certificate, oidExtensionKeyUsage) && certificate.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		failed = append(failed, certificateCheck{checks.KeyUsage, "нет назначения ключа \"Цифровая подпись\""})
+	}
+
+	required := checks.RequiredExtendedKeyUsage
+	if required == nil {
+		required = defaultRequiredExtendedKeyUsage
+	}
+
+	// Сертификат без расширения EKU можно использовать для любых целей
+	if usages, ok := extendedKeyUsageOids(certificate); ok {
+		var missing []string
+		for _, oid := range required {
+			if !containsString(usages, oid) {
+				missing = append(missing, oid)
+			}
+		}
+
+		if len(missing) > 0 {
+			failed = append(failed, certificateCheck{checks.ExtendedKeyUsage, fmt.Sprintf("нет расширенного назначения ключа %s", strings.Join(missing, ", "))})
+		}
+	}
+
+	if now.After(certificate.NotAfter) {
+		failed = append(failed, certificateCheck{checks.Expired, fmt.Sprintf("истек срок действия (был действителен до %s)", certificate.NotAfter.Format("02.01.2006 15:04:05"))})
+	}
+
+	if now.Before(certificate.NotBefore) {
+		failed = append(failed, certificateCheck{checks.NotYetValid, fmt.Sprintf("срок действия еще не начался (действителен с %s)", certificate.NotBefore.Format("02.01.2006 15:04:05"))})
+	}
+	return failed
+}
+
+// applyCheckAction выводит непройденную проверку и возвращает ошибку для действий skip и fail
+func applyCheckAction(check certificateCheck, certificateFilename string) error {
+	switch action := getCheckAction(check.Action); action {
+	case CHECK_ACTION_FAIL:
+		slog.Error(fmt.Sprintf("Сертификат[%s]: %s, установка остановлена", certificateFilename, check.Failure))
+		return ErrCertificateCheckFail
+	case CHECK_ACTION_SKIP:
+		slog.Warn(fmt.Sprintf("Сертификат[%s]: %s, подпись пропущена", certificateFilename, check.Failure))
+		return ErrCertificateCheckSkip
+	default:
+		slog.Warn(fmt.Sprintf("Сертификат[%s]: %s", certificateFilename, check.Failure))
+		return nil
+	}
+}
+
+// CheckCertificate выполняет проверки сертификата перед установкой и возвращает
+// ErrCertificateCheckSkip или ErrCertificateCheckFail, если этого требует действие проверки
+func CheckCertificate(certificate *x509.Certificate, gostCertificate *cades.GostCertificate, certificateFilename string) error {
+	var result error
+	for _, check := range collectCertificateChecks(certificate, gostCertificate, time.Now()) {
+		err := applyCheckAction(check, certificateFilename)
+		if err != nil && !errors.Is(result, ErrCertificateCheckFail) {
+			result = err
+		}
+	}
+	return result
+}
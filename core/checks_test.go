@@ -0,0 +1,173 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+)
+
+func newTestCertificate(t *testing.T, template *x509.Certificate) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if template.SerialNumber == nil {
+		template.SerialNumber = big.NewInt(1)
+	}
+	if template.Subject.CommonName == "" {
+		template.Subject = pkix.Name{CommonName: "Иванов Иван Иванович"}
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func setTestCertificateChecks(t *testing.T, checks SettingsChecksBlock) {
+	t.Helper()
+
+	if err := SetCertificateChecks(checks); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { certificateChecks = SettingsChecksBlock{} })
+}
+
+func TestCollectCertificateChecks(t *testing.T) {
+	setTestCertificateChecks(t, SettingsChecksBlock{})
+
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	valid := x509.Certificate{
+		NotBefore:   now.AddDate(-1, 0, 0),
+		NotAfter:    now.AddDate(1, 0, 0),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageEmailProtection},
+	}
+
+	tests := []struct {
+		name      string
+		modify    func(c *x509.Certificate)
+		algorithm string
+		failures  []string
+	}{
+		{"без замечаний", func(c *x509.Certificate) {}, "1.2.643.7.1.1.1.1", nil},
+		{"без расширения KeyUsage", func(c *x509.Certificate) { c.KeyUsage = 0 }, "1.2.643.7.1.1.1.1", nil},
+		{"без цифровой подписи", func(c *x509.Certificate) { c.KeyUsage = x509.KeyUsageKeyEncipherment }, "1.2.643.7.1.1.1.1", []string{"Цифровая подпись"}},
+		{"без расширения EKU", func(c *x509.Certificate) { c.ExtKeyUsage = nil }, "1.2.643.7.1.1.1.1", nil},
+		{"без проверки подлинности клиента", func(c *x509.Certificate) { c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth} }, "1.2.643.7.1.1.1.1", []string{"1.3.6.1.5.5.7.3.2"}},
+		{"истек", func(c *x509.Certificate) { c.NotAfter = now.AddDate(0, 0, -1) }, "1.2.643.7.1.1.1.1", []string{"истек срок действия"}},
+		{"еще не действует", func(c *x509.Certificate) { c.NotBefore = now.AddDate(0, 0, 1) }, "1.2.643.7.1.1.1.1", []string{"еще не начался"}},
+		{"устаревший алгоритм", func(c *x509.Certificate) {}, "1.2.643.2.2.19", []string{"ГОСТ Р 34.10-2001"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			template := valid
+			test.modify(&template)
+			certificate := newTestCertificate(t, &template)
+			gostCertificate := &cades.GostCertificate{Algorithm: cades.AlgorithmInfo{OID: test.algorithm}}
+
+			failed := collectCertificateChecks(certificate, gostCertificate, now)
+			if len(failed) != len(test.failures) {
+				t.Fatalf("collectCertificateChecks() = %v, want %v", failed, test.failures)
+			}
+
+			for i, failure := range test.failures {
+				if !strings.Contains(failed[i].Failure, failure) {
+					t.Errorf("collectCertificateChecks()[%d] = %q, want %q", i, failed[i].Failure, failure)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckCertificate(t *testing.T) {
+	expired := newTestCertificate(t, &x509.Certificate{
+		NotBefore: time.Now().AddDate(-2, 0, 0),
+		NotAfter:  time.Now().AddDate(-1, 0, 0),
+		KeyUsage:  x509.KeyUsageKeyEncipherment,
+	})
+	gostCertificate := &cades.GostCertificate{Algorithm: cades.AlgorithmInfo{OID: "1.2.643.7.1.1.1.1"}}
+
+	action := func(value string) *string { return &value }
+	tests := []struct {
+		name   string
+		checks SettingsChecksBlock
+		err    error
+	}{
+		{"по умолчанию warn", SettingsChecksBlock{}, nil},
+		{"warn", SettingsChecksBlock{Expired: action("warn"), KeyUsage: action("warn")}, nil},
+		{"skip", SettingsChecksBlock{Expired: action("skip")}, ErrCertificateCheckSkip},
+		{"fail", SettingsChecksBlock{Expired: action("FAIL")}, ErrCertificateCheckFail},
+		{"fail важнее skip", SettingsChecksBlock{Expired: action("fail"), KeyUsage: action("skip")}, ErrCertificateCheckFail},
+		{"skip и fail в обратном порядке", SettingsChecksBlock{Expired: action("skip"), KeyUsage: action("fail")}, ErrCertificateCheckFail},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestCertificateChecks(t, test.checks)
+
+			err := CheckCertificate(expired, gostCertificate, "expired.cer")
+			if !errors.Is(err, test.err) {
+				t.Errorf("CheckCertificate() = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestSetCertificateChecks(t *testing.T) {
+	t.Cleanup(func() { certificateChecks = SettingsChecksBlock{} })
+
+	action := func(value string) *string { return &value }
+	if err := SetCertificateChecks(SettingsChecksBlock{Expired: action("skpi")}); !errors.Is(err, ErrCheckAction) || !strings.Contains(err.Error(), "checks.expired") {
+		t.Errorf("SetCertificateChecks(skpi) = %v, want ErrCheckAction for checks.expired", err)
+	}
+
+	if err := SetCertificateChecks(SettingsChecksBlock{Revoked: action(" Skip ")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := getCheckAction(certificateChecks.Revoked); got != CHECK_ACTION_SKIP {
+		t.Errorf("getCheckAction() = %q, want %q", got, CHECK_ACTION_SKIP)
+	}
+}
+
+func TestParseCheckAction(t *testing.T) {
+	tests := []struct {
+		input  string
+		result string
+		err    error
+	}{
+		{"", CHECK_ACTION_WARN, nil},
+		{"warn", CHECK_ACTION_WARN, nil},
+		{"Skip", CHECK_ACTION_SKIP, nil},
+		{" FAIL ", CHECK_ACTION_FAIL, nil},
+		{"skpi", "", ErrCheckAction},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			result, err := ParseCheckAction(test.input)
+			if result != test.result || !errors.Is(err, test.err) {
+				t.Errorf("ParseCheckAction(%q) = %q, %v, want %q, %v", test.input, result, err, test.result, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package core
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+	"golang.org/x/exp/slog"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var (
+	ErrContainerCertificateNotFound = errors.New("certificate not found in container")
+	ErrContainerMalformed           = errors.New("container is malformed")
+)
+
+// ContainerIntegrityError описывает поврежденный файл контейнера
+type ContainerIntegrityError struct {
+	File   string
+	Reason string
+}
+
+func (e *ContainerIntegrityError) Error() string {
+	return fmt.Sprintf("файл[%s]: %s", e.File, e.Reason)
+}
+
+func (e *ContainerIntegrityError) Unwrap() error {
+	return ErrContainerMalformed
+}
+
+// ExtractContainerCertificate ищет сертификат, сохраненный в header.key контейнера
+func ExtractContainerCertificate(containerPath string) (*x509.Certificate, error) {
+	headerData, err := os.ReadFile(filepath.Join(containerPath, "header.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Сертификат хранится внутри ASN.1 структуры заголовка в DER, поэтому ищется по началу SEQUENCE
+	for i := 0; i < len(headerData)-4; i++ {
+		if headerData[i] != 0x30 || headerData[i+1] != 0x82 {
+			continue
+		}
+
+		var raw asn1.RawValue
+		rest, err := asn1.Unmarshal(headerData[i:], &raw)
+		if err != nil {
+			continue
+		}
+
+		certificate, err := x509.ParseCertificate(headerData[i : len(headerData)-len(rest)])
+		if err != nil {
+			continue
+		}
+
+		if !certificate.IsCA && certificate.PublicKey == nil {
+			return certificate, nil
+		}
+	}
+	return nil, ErrContainerCertificateNotFound
+}
+
+// ExtractEmbeddedCertificate сохраняет сертификат из контейнера или pfx файла во временную директорию
+func ExtractEmbeddedCertificate(containerPath string, pfxPassword string) (string, error) {
+	var certificate *x509.Certificate
+	var err error
+
+	if IsPfxFile(containerPath) {
+		certificate, err = GetPfxCertificate(containerPath, pfxPassword)
+	} else {
+		certificate, err = ExtractContainerCertificate(containerPath)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	certificatePath, err := SaveTempCertificate(certificate, filepath.Base(containerPath))
+	if err != nil {
+		return "", err
+	}
+
+	slog.Debug(fmt.Sprintf("Certificate extracted from container[%s] to %s", containerPath, certificatePath))
+	return certificatePath, nil
+}
+
+// ReadContainerName возвращает имя контейнера из name.key, для pfx - имя файла
+func ReadContainerName(containerPath string) string {
+	if IsPfxFile(containerPath) {
+		return filepath.Base(containerPath)
+	}
+
+	data, err := os.ReadFile(filepath.Join(containerPath, "name.key"))
+	if err != nil {
+		return filepath.Base(containerPath)
+	}
+
+	var name asn1.RawValue
+	var nameBlock asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &nameBlock); err != nil {
+		return filepath.Base(containerPath)
+	}
+	if _, err := asn1.Unmarshal(nameBlock.Bytes, &name); err != nil {
+		return filepath.Base(containerPath)
+	}
+
+	decoded, err := charmap.Windows1251.NewDecoder().Bytes(name.Bytes)
+	if err != nil {
+		return string(name.Bytes)
+	}
+	return string(decoded)
+}
+
+// ContainerShortPublicKey возвращает начало открытого ключа из header.key, пустую строку если ключ не найден
+func ContainerShortPublicKey(headerData []byte) string {
+	// Поиск как в cades.GetShortPublicKeyFromPrivateKey, но без выхода за границы поврежденного файла
+	marker := bytes.Index(headerData, []byte{0x8a, 0x08})
+	if marker < 0 || marker+10 > len(headerData) {
+		return ""
+	}
+	return hex.EncodeToString(headerData[marker+2 : marker+10])
+}
+
+func readContainerFile(containerPath string, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(containerPath, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &ContainerIntegrityError{File: name, Reason: "файл не существует"}
+	} else if err != nil {
+		return nil, &ContainerIntegrityError{File: name, Reason: err.Error()}
+	}
+
+	if len(data) == 0 {
+		return nil, &ContainerIntegrityError{File: name, Reason: "файл пустой"}
+	}
+	return data, nil
+}
+
+// parseContainerSequence проверяет, что файл содержит одну ASN.1 SEQUENCE без лишних данных
+func parseContainerSequence(name string, data []byte) (*asn1.RawValue, error) {
+	var sequence asn1.RawValue
+	rest, err := asn1.Unmarshal(data, &sequence)
+	if err != nil {
+		return nil, &ContainerIntegrityError{File: name, Reason: fmt.Sprintf("некорректная ASN.1 структура: %s", err)}
+	}
+
+	if sequence.Class != asn1.ClassUniversal || sequence.Tag != asn1.TagSequence {
+		return nil, &ContainerIntegrityError{File: name, Reason: "ожидается ASN.1 SEQUENCE"}
+	}
+
+	// Дополнение нулями допускается, остальные данные после структуры означают повреждение
+	if len(bytes.TrimRight(rest, "\x00")) > 0 {
+		return nil, &ContainerIntegrityError{File: name, Reason: fmt.Sprintf("лишние данные после ASN.1 структуры (%d байт)", len(rest))}
+	}
+	return &sequence, nil
+}
+
+// parseContainerKeyFile разбирает primary.key или masks.key: SEQUENCE из OCTET STRING
+func parseContainerKeyFile(containerPath string, name string) ([][]byte, error) {
+	data, err := readContainerFile(containerPath, name)
+	if err != nil {
+		return nil, err
+	}
+
+	sequence, err := parseContainerSequence(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields [][]byte
+	rest := sequence.Bytes
+	for len(rest) > 0 {
+		var field []byte
+		rest, err = asn1.Unmarshal(rest, &field)
+		if err != nil {
+			return nil, &ContainerIntegrityError{File: name, Reason: fmt.Sprintf("ожидается OCTET STRING: %s", err)}
+		}
+		fields = append(fields, field)
+	}
+
+	if len(fields) == 0 {
+		return nil, &ContainerIntegrityError{File: name, Reason: "нет данных ключа"}
+	}
+	return fields, nil
+}
+
+func validateContainerKey(containerPath string, primaryName string, masksName string) ([][]byte, [][]byte, error) {
+	primary, err := parseContainerKeyFile(containerPath, primaryName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyLength := len(primary[0])
+	if len(primary) != 1 || (keyLength != 32 && keyLength != 64) {
+		return nil, nil, &ContainerIntegrityError{
+			File:   primaryName,
+			Reason: fmt.Sprintf("неожиданная длина ключа %d байт (ожидается 32 или 64)", keyLength),
+		}
+	}
+
+	masks, err := parseContainerKeyFile(containerPath, masksName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(masks[0]) != keyLength {
+		return nil, nil, &ContainerIntegrityError{
+			File:   masksName,
+			Reason: fmt.Sprintf("длина маски %d байт не совпадает с длиной ключа %d байт в %s", len(masks[0]), keyLength, primaryName),
+		}
+	}
+	return primary, masks, nil
+}
+
+func isSameKeyLayout(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateContainer проверяет структуру файлов контейнера и, если передан сертификат,
+// совпадение открытого ключа контейнера с сертификатом
+func ValidateContainer(containerPath string, certificate *x509.Certificate) error {
+	headerData, err := readContainerFile(containerPath, "header.key")
+	if err != nil {
+		return err
+	}
+
+	if _, err := parseContainerSequence("header.key", headerData); err != nil {
+		return err
+	}
+
+	containerPublicKey := ContainerShortPublicKey(headerData)
+	if containerPublicKey == "" {
+		return &ContainerIntegrityError{File: "header.key", Reason: "не найден открытый ключ"}
+	}
+
+	primary, masks, err := validateContainerKey(containerPath, "primary.key", "masks.key")
+	if err != nil {
+		return err
+	}
+
+	primary2, masks2, err := validateContainerKey(containerPath, "primary2.key", "masks2.key")
+	if err != nil {
+		return err
+	}
+
+	if !isSameKeyLayout(primary, primary2) {
+		return &ContainerIntegrityError{File: "primary2.key", Reason: "структура не совпадает с primary.key"}
+	}
+
+	if !isSameKeyLayout(masks, masks2) {
+		return &ContainerIntegrityError{File: "masks2.key", Reason: "структура не совпадает с masks.key"}
+	}
+
+	if certificate == nil {
+		return nil
+	}
+
+	subjectPublicKeyInfo, err := cades.ParseSubjectPublicKeyInfo(certificate)
+	if err != nil {
+		return err
+	}
+
+	if cades.GetCertificateShortPublicKey(subjectPublicKeyInfo) != containerPublicKey {
+		return &ContainerIntegrityError{File: "header.key", Reason: "открытый ключ контейнера не совпадает с сертификатом"}
+	}
+	return nil
+}
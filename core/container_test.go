@@ -0,0 +1,170 @@
+package core
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+)
+
+func marshalTestSequence(t *testing.T, fields ...[]byte) []byte {
+	t.Helper()
+
+	var content []byte
+	for _, field := range fields {
+		content = append(content, field...)
+	}
+
+	data, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func marshalTestOctets(t *testing.T, size int) []byte {
+	t.Helper()
+
+	data, err := asn1.Marshal(make([]byte, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// newTestContainerFiles возвращает файлы синтетического контейнера с открытым ключом shortPublicKey в header.key
+func newTestContainerFiles(t *testing.T, shortPublicKey []byte) map[string][]byte {
+	t.Helper()
+
+	publicKey, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 10, Bytes: shortPublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := marshalTestSequence(t, marshalTestOctets(t, 32))
+	masks := marshalTestSequence(t, marshalTestOctets(t, 32), marshalTestOctets(t, 12))
+	return map[string][]byte{
+		"header.key":   marshalTestSequence(t, publicKey),
+		"name.key":     marshalTestSequence(t),
+		"primary.key":  primary,
+		"masks.key":    masks,
+		"primary2.key": primary,
+		"masks2.key":   masks,
+	}
+}
+
+func writeTestContainer(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+
+	containerPath := filepath.Join(t.TempDir(), "test.000")
+	if err := os.Mkdir(containerPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(containerPath, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return containerPath
+}
+
+// newTestGostCertificate возвращает сертификат, в котором заполнен только открытый ключ ГОСТ Р 34.10-2012 256 бит
+func newTestGostCertificate(t *testing.T, publicKey []byte) *x509.Certificate {
+	t.Helper()
+
+	subjectPublicKeyInfo, err := asn1.Marshal(cades.SubjectPublicKeyInfoAsn1{
+		AlgorithmInfo: cades.AlgorithmInfoAsn1{
+			AlgorithmOID:  asn1.ObjectIdentifier{1, 2, 643, 7, 1, 1, 1, 1},
+			ParameterOIDs: []asn1.ObjectIdentifier{{1, 2, 643, 7, 1, 2, 1, 1, 1}},
+		},
+		PublicKey: asn1.BitString{Bytes: append([]byte{0x04, 0x40}, publicKey...), BitLength: (len(publicKey) + 2) * 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &x509.Certificate{RawSubjectPublicKeyInfo: subjectPublicKeyInfo}
+}
+
+func TestValidateContainer(t *testing.T) {
+	publicKey := make([]byte, 64)
+	for i := range publicKey {
+		publicKey[i] = byte(i + 1)
+	}
+	certificate := newTestGostCertificate(t, publicKey)
+	shortPublicKey := publicKey[:8]
+
+	otherPublicKey := make([]byte, len(shortPublicKey))
+	for i := range shortPublicKey {
+		otherPublicKey[i] = ^shortPublicKey[i]
+	}
+
+	tests := []struct {
+		name        string
+		modify      func(files map[string][]byte)
+		certificate *x509.Certificate
+		file        string
+	}{
+		{"корректный контейнер", func(files map[string][]byte) {}, certificate, ""},
+		{"без проверки сертификата", func(files map[string][]byte) {}, nil, ""},
+		{"нет файла ключа", func(files map[string][]byte) {
+			delete(files, "primary2.key")
+		}, nil, "primary2.key"},
+		{"пустой файл", func(files map[string][]byte) {
+			files["masks.key"] = nil
+		}, nil, "masks.key"},
+		{"поврежденная ASN.1 структура", func(files map[string][]byte) {
+			files["primary.key"] = files["primary.key"][:10]
+		}, nil, "primary.key"},
+		{"лишние данные после структуры", func(files map[string][]byte) {
+			files["header.key"] = append(files["header.key"], 0x01, 0x02)
+		}, nil, "header.key"},
+		{"нет открытого ключа", func(files map[string][]byte) {
+			files["header.key"] = marshalTestSequence(t)
+		}, nil, "header.key"},
+		{"неверная длина ключа", func(files map[string][]byte) {
+			files["primary.key"] = marshalTestSequence(t, marshalTestOctets(t, 16))
+		}, nil, "primary.key"},
+		{"неверная длина маски", func(files map[string][]byte) {
+			files["masks.key"] = marshalTestSequence(t, marshalTestOctets(t, 64), marshalTestOctets(t, 12))
+		}, nil, "masks.key"},
+		{"primary2.key не совпадает с primary.key", func(files map[string][]byte) {
+			files["primary2.key"] = marshalTestSequence(t, marshalTestOctets(t, 64))
+			files["masks2.key"] = marshalTestSequence(t, marshalTestOctets(t, 64), marshalTestOctets(t, 12))
+		}, nil, "primary2.key"},
+		{"masks2.key не совпадает с masks.key", func(files map[string][]byte) {
+			files["masks2.key"] = marshalTestSequence(t, marshalTestOctets(t, 32))
+		}, nil, "masks2.key"},
+		{"открытый ключ не совпадает с сертификатом", func(files map[string][]byte) {
+			files["header.key"] = newTestContainerFiles(t, otherPublicKey)["header.key"]
+		}, certificate, "header.key"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := newTestContainerFiles(t, shortPublicKey)
+			test.modify(files)
+
+			err := ValidateContainer(writeTestContainer(t, files), test.certificate)
+			if test.file == "" {
+				if err != nil {
+					t.Fatalf("ValidateContainer() error = %v", err)
+				}
+				return
+			}
+
+			var integrityError *ContainerIntegrityError
+			if !errors.As(err, &integrityError) || !errors.Is(err, ErrContainerMalformed) {
+				t.Fatalf("ValidateContainer() error = %v, want ContainerIntegrityError", err)
+			}
+
+			if integrityError.File != test.file {
+				t.Errorf("ValidateContainer() file = %s, want %s (%s)", integrityError.File, test.file, integrityError.Reason)
+			}
+		})
+	}
+}
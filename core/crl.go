@@ -0,0 +1,171 @@
+package core
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+	"golang.org/x/exp/slog"
+)
+
+// Списки отзыва берутся из папок certs/root и certs/crl и устанавливаются в хранилище промежуточных сертификатов
+const (
+	CRL_FOLDER_NAME = "crl"
+	CRL_STORE       = CERTIFICATE_STORE_CA
+)
+
+var ErrNotCRL = errors.New("file is not a CRL")
+
+// CRLFile - список отзыва из папки certs
+type CRLFile struct {
+	Path string
+	CRL  *x509.RevocationList
+}
+
+// LoadCRLFile определяет список отзыва по содержимому файла (DER, PEM или base64)
+func LoadCRLFile(path string) (*x509.RevocationList, error) {
+	data, err := readCertificateFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, _ := DecodeCertificateData(data)
+	crl, err := x509.ParseRevocationList(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotCRL, err)
+	}
+	return crl, nil
+}
+
+func GetCRLThumbprint(crl *x509.RevocationList) string {
+	hash := sha1.Sum(crl.Raw)
+	return hex.EncodeToString(hash[:])
+}
+
+// FindCRLFiles возвращает списки отзыва из папок root и crl, файлы с ошибкой манифеста пропускаются
+func FindCRLFiles(certsFolderPath string) []*CRLFile {
+	var result []*CRLFile
+
+	for _, folder := range []string{"root", CRL_FOLDER_NAME} {
+		folderPath := filepath.Join(certsFolderPath, folder)
+		folderEntity, err := os.ReadDir(folderPath)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				slog.Debug(fmt.Sprintf("Cant get entities from folder[%s], error: %s", folderPath, err.Error()))
+			}
+			continue
+		}
+
+		for _, entity := range folderEntity {
+			if entity.IsDir() {
+				continue
+			}
+
+			path := filepath.Join(folderPath, entity.Name())
+			crl, err := LoadCRLFile(path)
+			if err != nil {
+				continue
+			}
+
+			if err := CheckManifestIntegrity(path); err != nil {
+				slog.Error(fmt.Sprintf("Список отзыва[%s] пропущен: %s", entity.Name(), err))
+				continue
+			}
+			result = append(result, &CRLFile{Path: path, CRL: crl})
+		}
+	}
+	return result
+}
+
+// getInstalledCRLs возвращает вывод certmgr со списками отзыва из хранилища
+func getInstalledCRLs() string {
+	output, err := cades.NewCertManagerProcess("-list", "-crl", "-store", CRL_STORE)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant get list of CRLs from store[%s]: %s", CRL_STORE, err))
+	}
+	return strings.ToLower(output)
+}
+
+func saveTempCRL(crl *x509.RevocationList, name string) (string, error) {
+	temp, err := GetTempFolder()
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp(temp, strings.TrimSuffix(name, filepath.Ext(name))+"-*.crl")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	_, err = file.Write(crl.Raw)
+	return file.Name(), err
+}
+
+// InstallCRL устанавливает список отзыва, файлы не в DER сохраняются во временную папку
+func InstallCRL(file *CRLFile) error {
+	path := file.Path
+	data, err := readCertificateFile(path)
+	if err != nil {
+		return err
+	}
+
+	if _, encoding := DecodeCertificateData(data); encoding != CERTIFICATE_ENCODING_DER {
+		path, err = saveTempCRL(file.CRL, filepath.Base(file.Path))
+		if err != nil {
+			return err
+		}
+	}
+
+	output, err := cades.NewCertManagerProcess("-inst", "-crl", "-file", path, "-store", CRL_STORE)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Fail to install CRL[%s] to store[%s], error: %s", path, CRL_STORE, err))
+		slog.Debug(fmt.Sprintf("Certmgr log: %s", output))
+	}
+	return err
+}
+
+// InstallCRLs устанавливает списки отзыва из папок root и crl, уже установленные пропускаются
+func InstallCRLs(certsFolderPath string) {
+	files := FindCRLFiles(certsFolderPath)
+	if len(files) == 0 {
+		return
+	}
+
+	installedCRLs := getInstalledCRLs()
+	now := time.Now()
+	installed, existing := 0, 0
+	for _, file := range files {
+		filename := filepath.Base(file.Path)
+		issuer := file.CRL.Issuer.CommonName
+
+		if !file.CRL.NextUpdate.IsZero() && now.After(file.CRL.NextUpdate) {
+			slog.Warn(fmt.Sprintf(
+				"Список отзыва[%s] издателя %s устарел (следующее обновление %s)",
+				filename, issuer, file.CRL.NextUpdate.Format("02.01.2006 15:04:05"),
+			))
+		}
+
+		if strings.Contains(installedCRLs, GetCRLThumbprint(file.CRL)) {
+			slog.Debug(fmt.Sprintf("CRL[%s] exists in store[%s]", file.Path, CRL_STORE))
+			existing++
+			continue
+		}
+
+		if err := InstallCRL(file); err != nil {
+			slog.Error(fmt.Sprintf("Не удалось установить список отзыва[%s]: %s", filename, err))
+			continue
+		}
+
+		installed++
+		slog.Info(fmt.Sprintf("Список отзыва[%s] издателя %s установлен", filename, issuer))
+	}
+	slog.Info(fmt.Sprintf("Списки отзыва: новых %d, уже установлено %d", installed, existing))
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+	"golang.org/x/exp/slog"
+)
+
+// Политики выбора при совпадении открытого ключа у нескольких файлов:
+//
+//	newest - контейнер или pfx, измененный позже остальных
+//	folder - как newest, но контейнер в папке предпочитается его копии в pfx (по умолчанию)
+//	ask    - выбор пользователем
+//
+// Для сертификатов при newest и folder выбирается действующий сертификат с самым поздним сроком окончания.
+const (
+	DUPLICATE_POLICY_NEWEST = "newest"
+	DUPLICATE_POLICY_FOLDER = "folder"
+	DUPLICATE_POLICY_ASK    = "ask"
+)
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func askDuplicateChoice(description string, paths []string) int {
+	fmt.Println()
+	fmt.Println(description)
+	for i, path := range paths {
+		fmt.Printf("  %d. %s\n", i+1, path)
+	}
+
+	for {
+		fmt.Printf("Выберите номер [1-%d]: ", len(paths))
+		var answer string
+		_, err := fmt.Scanln(&answer)
+		if err != nil && answer == "" {
+			return 0
+		}
+
+		choice, err := strconv.Atoi(strings.TrimSpace(answer))
+		if err == nil && choice >= 1 && choice <= len(paths) {
+			return choice - 1
+		}
+	}
+}
+
+func reportDuplicates(kind string, key string, paths []string, chosen string, policy string) {
+	slog.Warn(fmt.Sprintf(
+		"Найдено %d %s с одинаковым открытым ключом[%s]: %s; выбран[%s] (политика: %s)",
+		len(paths), kind, key, strings.Join(paths, ", "), chosen, policy,
+	))
+}
+
+// selectCertificates выбирает для каждого ключа один сертификат: при перевыпуске на тот же ключ
+// предпочитается действующий сертификат с самым поздним сроком окончания.
+// Остальные сертификаты (без копий выбранного) возвращаются вторым значением.
+func selectCertificates(certificatePublicKeys map[string][]*discoveredCertificate, policy string) (map[string]*discoveredCertificate, map[string][]*discoveredCertificate) {
+	result := make(map[string]*discoveredCertificate)
+	others := make(map[string][]*discoveredCertificate)
+	now := time.Now()
+
+	for _, k := range sortedKeys(certificatePublicKeys) {
+		candidates := certificatePublicKeys[k]
+		if len(candidates) == 1 {
+			result[k] = candidates[0]
+			continue
+		}
+
+		sort.SliceStable(candidates, func(i, j int) bool {
+			validI := isCertificateValidAt(candidates[i].Certificate, now)
+			validJ := isCertificateValidAt(candidates[j].Certificate, now)
+			if validI != validJ {
+				return validI
+			}
+			return candidates[i].Certificate.NotAfter.After(candidates[j].Certificate.NotAfter)
+		})
+
+		paths := make([]string, len(candidates))
+		thumbprints := make(map[string]bool)
+		for i, c := range candidates {
+			paths[i] = c.Path
+			thumbprints[cades.GetThumbprint(c.Certificate)] = true
+		}
+
+		// Копии одного и того же сертификата не требуют выбора
+		if len(thumbprints) == 1 {
+			slog.Debug(fmt.Sprintf("Certificate copies with key %s: %s", k, strings.Join(paths, ", ")))
+			result[k] = candidates[0]
+			continue
+		}
+
+		chosen := 0
+		if policy == DUPLICATE_POLICY_ASK {
+			descriptions := make([]string, len(candidates))
+			for i, c := range candidates {
+				descriptions[i] = fmt.Sprintf(
+					"%s (действителен с %s до %s)", c.Path,
+					c.Certificate.NotBefore.Format("02.01.2006"), c.Certificate.NotAfter.Format("02.01.2006"),
+				)
+			}
+			chosen = askDuplicateChoice(fmt.Sprintf("Найдено несколько сертификатов с открытым ключом[%s]:", k), descriptions)
+		}
+		result[k] = candidates[chosen]
+
+		chosenThumbprint := cades.GetThumbprint(candidates[chosen].Certificate)
+		seen := map[string]bool{chosenThumbprint: true}
+		for _, c := range candidates {
+			thumbprint := cades.GetThumbprint(c.Certificate)
+			if !seen[thumbprint] {
+				seen[thumbprint] = true
+				others[k] = append(others[k], c)
+			}
+		}
+
+		slog.Info(fmt.Sprintf(
+			"Найдено %d сертификатов на одном ключе[%s]: %s; выбран[%s], действителен до %s",
+			len(candidates), k, strings.Join(paths, ", "), candidates[chosen].Path,
+			candidates[chosen].Certificate.NotAfter.Format("02.01.2006"),
+		))
+	}
+	return result, others
+}
+
+func isCertificateValidAt(certificate *x509.Certificate, t time.Time) bool {
+	return !t.Before(certificate.NotBefore) && !t.After(certificate.NotAfter)
+}
+
+func selectContainers(containerPublicKeys map[string][]*discoveredContainer, policy string) map[string]*discoveredContainer {
+	result := make(map[string]*discoveredContainer)
+	for _, k := range sortedKeys(containerPublicKeys) {
+		candidates := containerPublicKeys[k]
+		if len(candidates) == 1 {
+			result[k] = candidates[0]
+			continue
+		}
+
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if policy != DUPLICATE_POLICY_NEWEST && candidates[i].IsPfx != candidates[j].IsPfx {
+				return !candidates[i].IsPfx
+			}
+			return candidates[i].ModTime.After(candidates[j].ModTime)
+		})
+
+		paths := make([]string, len(candidates))
+		for i, c := range candidates {
+			paths[i] = c.Path
+		}
+
+		chosen := 0
+		if policy == DUPLICATE_POLICY_ASK {
+			chosen = askDuplicateChoice(fmt.Sprintf("Найдено несколько контейнеров с открытым ключом[%s]:", k), paths)
+		}
+		result[k] = candidates[chosen]
+		reportDuplicates("контейнеров", k, paths, candidates[chosen].Path, policy)
+	}
+	return result
+}
@@ -1,60 +1,236 @@
 package core
 
 import (
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
 	"golang.org/x/exp/slog"
 )
 
-func extractPublicKeyFromCertificates(certificatesPath []string) map[string]string {
-	certificatePublicKeys := make(map[string]string)
-	for _, path := range certificatesPath {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			slog.Debug(fmt.Sprintf("Cant read file %s: %v", path, err))
-			continue
+type discoveredCertificate struct {
+	Path        string
+	Certificate *x509.Certificate
+}
+
+type discoveredContainer struct {
+	Path    string
+	IsPfx   bool
+	ModTime time.Time
+}
+
+// Файлы читаются параллельно: на сетевых папках большую часть времени занимает ожидание чтения
+const DISCOVERY_WORKERS = 16
+
+func forEachParallel(count int, fn func(i int)) {
+	var wg sync.WaitGroup
+	indexes := make(chan int)
+
+	workers := DISCOVERY_WORKERS
+	if count < workers {
+		workers = count
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+func loadDiscoveredCertificate(path string, cache *discoveryCache) (*discoveredCertificate, string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant read file %s: %v", path, err))
+		return nil, ""
+	}
+
+	if entry, ok := cache.get(DISCOVERY_KIND_CERTIFICATE, path, info); ok {
+		if entry.PublicKey == "" {
+			return nil, ""
 		}
 
-		certificate, err := cades.LoadCertificate(data)
-		if err != nil {
-			slog.Debug(fmt.Sprintf("Cant parse certificate %s: %v", path, err))
-			continue
+		certificate, err := x509.ParseCertificate(entry.Certificate)
+		if err == nil {
+			return &discoveredCertificate{Path: path, Certificate: certificate}, entry.PublicKey
 		}
+	}
+
+	// Ошибка чтения (например, на сетевой папке) не кэшируется, файл будет прочитан при следующем поиске
+	data, err := readCertificateFile(path)
+	if err != nil && !errors.Is(err, ErrNotCertificate) {
+		slog.Debug(fmt.Sprintf("Cant read file %s: %v", path, err))
+		return nil, ""
+	}
+
+	entry := &discoveryCacheEntry{}
+	defer cache.put(DISCOVERY_KIND_CERTIFICATE, path, info, entry)
+
+	if err != nil {
+		return nil, ""
+	}
+
+	certificate, err := LoadCertificateData(data)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant parse certificate %s: %v", path, err))
+		return nil, ""
+	}
+
+	if certificate.IsCA || certificate.PublicKey != nil {
+		return nil, ""
+	}
+
+	subjectPublicKeyInfo, err := cades.ParseSubjectPublicKeyInfo(certificate)
+	if err != nil {
+		return nil, ""
+	}
+
+	entry.PublicKey = cades.GetCertificateShortPublicKey(subjectPublicKeyInfo)
+	entry.Certificate = certificate.Raw
+	return &discoveredCertificate{Path: path, Certificate: certificate}, entry.PublicKey
+}
 
-		if certificate.IsCA || certificate.PublicKey != nil {
+func extractPublicKeyFromCertificates(certificatesPath []string, cache *discoveryCache) map[string][]*discoveredCertificate {
+	certificates := make([]*discoveredCertificate, len(certificatesPath))
+	publicKeys := make([]string, len(certificatesPath))
+	forEachParallel(len(certificatesPath), func(i int) {
+		certificates[i], publicKeys[i] = loadDiscoveredCertificate(certificatesPath[i], cache)
+	})
+
+	certificatePublicKeys := make(map[string][]*discoveredCertificate)
+	for i, certificate := range certificates {
+		if certificate == nil {
 			continue
 		}
+		certificatePublicKeys[publicKeys[i]] = append(certificatePublicKeys[publicKeys[i]], certificate)
+	}
 
-		subjectPublicKeyInfo, err := cades.ParseSubjectPublicKeyInfo(certificate)
-		if err != nil {
+	return certificatePublicKeys
+}
+
+func loadDiscoveredContainer(path string, cache *discoveryCache) (*discoveredContainer, string) {
+	headerPath := filepath.Join(path, "header.key")
+
+	headerInfo, err := os.Stat(headerPath)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant read container header file %s: %v", headerPath, err))
+		return nil, ""
+	}
+
+	container := &discoveredContainer{Path: path, ModTime: headerInfo.ModTime()}
+	if entry, ok := cache.get(DISCOVERY_KIND_CONTAINER, headerPath, headerInfo); ok {
+		return container, entry.PublicKey
+	}
+
+	headerData, err := os.ReadFile(headerPath)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant read container header file %s: %v", headerPath, err))
+		return nil, ""
+	}
+
+	publicKey := ContainerShortPublicKey(headerData)
+	cache.put(DISCOVERY_KIND_CONTAINER, headerPath, headerInfo, &discoveryCacheEntry{PublicKey: publicKey})
+	return container, publicKey
+}
+
+func extractPublicKeyFromContainers(containersPath []string, cache *discoveryCache) map[string][]*discoveredContainer {
+	containers := make([]*discoveredContainer, len(containersPath))
+	publicKeys := make([]string, len(containersPath))
+	forEachParallel(len(containersPath), func(i int) {
+		containers[i], publicKeys[i] = loadDiscoveredContainer(containersPath[i], cache)
+	})
+
+	containerPublicKeys := make(map[string][]*discoveredContainer)
+	for i, container := range containers {
+		if container == nil {
 			continue
 		}
+		containerPublicKeys[publicKeys[i]] = append(containerPublicKeys[publicKeys[i]], container)
+	}
+	return containerPublicKeys
+}
 
-		shortPublicKey := cades.GetCertificateShortPublicKey(subjectPublicKeyInfo)
-		certificatePublicKeys[shortPublicKey] = path
+func loadDiscoveredPfx(path string, password string, cache *discoveryCache) (*discoveredContainer, string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant read pfx %s: %v", path, err))
+		return nil, ""
 	}
 
-	return certificatePublicKeys
+	container := &discoveredContainer{Path: path, IsPfx: true, ModTime: info.ModTime()}
+	if entry, ok := cache.get(DISCOVERY_KIND_PFX, path, info); ok {
+		return container, entry.PublicKey
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant read pfx %s: %v", path, err))
+		return container, ""
+	}
+
+	certificate, err := ParsePfxCertificate(data, password)
+	if errors.Is(err, ErrPfxEncrypted) {
+		// Результат зависит от пароля, поэтому не кэшируется
+		slog.Debug(fmt.Sprintf("Cant decrypt certificate from pfx %s", path))
+		return container, ""
+	}
+
+	entry := &discoveryCacheEntry{}
+	defer cache.put(DISCOVERY_KIND_PFX, path, info, entry)
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant get certificate from pfx %s: %v", path, err))
+		return container, ""
+	}
+
+	subjectPublicKeyInfo, err := cades.ParseSubjectPublicKeyInfo(certificate)
+	if err != nil {
+		return container, ""
+	}
+
+	entry.PublicKey = cades.GetCertificateShortPublicKey(subjectPublicKeyInfo)
+	return container, entry.PublicKey
 }
 
-func extractPublicKeyFromContainers(containersPath []string) map[string]string {
-	containerPublicKeys := make(map[string]string)
-	for _, path := range containersPath {
-		headerPath := filepath.Join(path, "header.key")
+// extractPublicKeyFromPfx возвращает ключи pfx файлов с доступным сертификатом
+// и pfx файлы, сертификат из которых получить не удалось (зашифрован или отсутствует)
+func extractPublicKeyFromPfx(pfxPaths []string, password string, cache *discoveryCache) (map[string][]*discoveredContainer, []string) {
+	containers := make([]*discoveredContainer, len(pfxPaths))
+	publicKeys := make([]string, len(pfxPaths))
+	forEachParallel(len(pfxPaths), func(i int) {
+		containers[i], publicKeys[i] = loadDiscoveredPfx(pfxPaths[i], password, cache)
+	})
 
-		headerData, err := os.ReadFile(headerPath)
-		if err != nil {
-			slog.Debug(fmt.Sprintf("Cant read container header file %s: %v", headerPath, err))
+	pfxPublicKeys := make(map[string][]*discoveredContainer)
+	var pfxWithoutCertificate []string
+	for i, container := range containers {
+		if container == nil {
 			continue
 		}
 
-		publicKey := cades.GetShortPublicKeyFromPrivateKey(headerData)
-		containerPublicKeys[publicKey] = path
+		if publicKeys[i] == "" {
+			pfxWithoutCertificate = append(pfxWithoutCertificate, container.Path)
+			continue
+		}
+		pfxPublicKeys[publicKeys[i]] = append(pfxPublicKeys[publicKeys[i]], container)
 	}
-	return containerPublicKeys
+	return pfxPublicKeys, pfxWithoutCertificate
 }
 
 type DigitalSignaturePair struct {
@@ -62,65 +238,231 @@ type DigitalSignaturePair struct {
 	Container   string
 }
 
-func FindDigitalSignaturePairs(path string) ([]*ESignatureInstallParams, error) {
-	var result []*ESignatureInstallParams
-	var certificates []string
-	var containers []string
+type signatureFiles struct {
+	certificates []string
+	containers   []string
+	pfx          []string
+}
+
+func collectSignatureFiles(root string, files *signatureFiles, matcher *IgnoreMatcher) {
+	var archives []string
 
-	_ = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+	_ = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return err
+			slog.Warn(fmt.Sprintf("Не удалось прочитать[%s]: %s", path, err))
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 
-		if info.Name() == "root" {
-			return filepath.SkipDir
+		relPath, err := filepath.Rel(root, path)
+		if err == nil && matcher.IsIgnored(filepath.ToSlash(relPath), info.IsDir()) {
+			slog.Debug(fmt.Sprintf("Path[%s] ignored", path))
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 
 		if info.IsDir() {
 			return nil
 		}
 
-		if filepath.Ext(path) == ".cer" {
-			certificates = append(certificates, path)
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Битая символическая ссылка[%s]: %s", path, err))
+				return nil
+			}
+
+			if target.IsDir() {
+				slog.Debug(fmt.Sprintf("Symlink to directory[%s] skipped", path))
+				return nil
+			}
+			info = target
+		}
+
+		if isCertificateCandidate(info.Name()) && info.Size() <= MAX_CERTIFICATE_FILE_SIZE {
+			files.certificates = append(files.certificates, path)
 		}
 
 		if info.Name() == "header.key" {
-			containers = append(containers, filepath.Dir(path))
+			files.containers = append(files.containers, filepath.Dir(path))
+		}
+
+		if IsPfxFile(path) {
+			files.pfx = append(files.pfx, path)
+		}
+
+		if IsZipArchive(path) {
+			archives = append(archives, path)
 		}
 
-		return err
+		return nil
 	})
 
-	certificatePublicKeys := extractPublicKeyFromCertificates(certificates)
-	containerPublicKeys := extractPublicKeyFromContainers(containers)
-	slog.Debug(fmt.Sprintf("Certificate count: %d", len(certificatePublicKeys)))
-	slog.Debug(fmt.Sprintf("Container count: %d", len(containerPublicKeys)))
+	for _, archive := range archives {
+		folder, err := ExtractZipArchive(archive)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Не удалось распаковать архив[%s]: %s", archive, err))
+			continue
+		}
+		collectSignatureFiles(folder, files, matcher)
+	}
+}
 
-	for k := range certificatePublicKeys {
-		_, ok := containerPublicKeys[k]
-		if !ok {
-			slog.Debug(fmt.Sprintf("Pair not found for %s, %v", k, certificatePublicKeys[k]))
+// relativePath возвращает путь относительно папки certs, пути вне ее (распакованные архивы) остаются абсолютными
+func relativePath(base string, path string) (string, error) {
+	relPath, err := filepath.Rel(base, path)
+	if err != nil {
+		return relPath, err
+	}
+
+	if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return path, nil
+	}
+	return relPath, nil
+}
+
+// findSiblingCertificate ищет сертификат с тем же именем, что и у pfx файла: Иванов.pfx -> Иванов.cer.
+// Используется для pfx, сертификат которых не удалось расшифровать, ключ проверяется после установки (ConfirmPfxKey)
+func findSiblingCertificate(pfxPath string, certificates []string) string {
+	pfxName := strings.TrimSuffix(pfxPath, filepath.Ext(pfxPath))
+	for _, certificate := range certificates {
+		if filepath.Dir(certificate) != filepath.Dir(pfxPath) {
 			continue
 		}
 
-		certificatePath, err := filepath.Rel(path, certificatePublicKeys[k])
+		if strings.EqualFold(strings.TrimSuffix(certificate, filepath.Ext(certificate)), pfxName) {
+			return certificate
+		}
+	}
+	return ""
+}
+
+type DiscoveryOptions struct {
+	DuplicatePolicy string
+	Include         []string
+	Exclude         []string
+	CachePath       string
+	// Пароль для чтения зашифрованных сертификатов pfx, обычно пароль pfx или PIN-код по умолчанию
+	PfxPassword string
+}
+
+func FindDigitalSignaturePairs(path string, options DiscoveryOptions) (*DiscoveryResult, error) {
+	result := &DiscoveryResult{}
+	var files signatureFiles
+
+	matcher, err := NewIgnoreMatcher(path, options.Include, options.Exclude)
+	if err != nil {
+		return result, fmt.Errorf("не удалось прочитать правила исключения: %w", err)
+	}
+
+	collectSignatureFiles(path, &files, matcher)
+
+	cache := loadDiscoveryCache(options.CachePath)
+	certificatePublicKeys := extractPublicKeyFromCertificates(files.certificates, cache)
+	containerPublicKeys := extractPublicKeyFromContainers(files.containers, cache)
+	pfxPublicKeys, pfxWithoutCertificate := extractPublicKeyFromPfx(files.pfx, options.PfxPassword, cache)
+	cache.save()
+	slog.Debug(fmt.Sprintf("Certificate count: %d", len(files.certificates)))
+	slog.Debug(fmt.Sprintf("Container count: %d", len(files.containers)))
+	slog.Debug(fmt.Sprintf("Pfx count: %d", len(files.pfx)))
+
+	for k, pfx := range pfxPublicKeys {
+		containerPublicKeys[k] = append(containerPublicKeys[k], pfx...)
+	}
+
+	certificates, otherCertificates := selectCertificates(certificatePublicKeys, options.DuplicatePolicy)
+	containers := selectContainers(containerPublicKeys, options.DuplicatePolicy)
+
+	// Пустой путь до сертификата означает, что сертификат будет взят из самого контейнера
+	addPair := func(certificatePath string, containerPath string, extraCertificates []*discoveredCertificate) {
+		var err error
+		if certificatePath != "" {
+			certificatePath, err = relativePath(path, certificatePath)
+			if err != nil {
+				slog.Debug(err.Error())
+				return
+			}
+		}
+
+		containerPath, err = relativePath(path, containerPath)
 		if err != nil {
 			slog.Debug(err.Error())
+			return
+		}
+
+		var extraCertificatePaths []string
+		for _, c := range extraCertificates {
+			extraPath, err := relativePath(path, c.Path)
+			if err == nil {
+				extraCertificatePaths = append(extraCertificatePaths, extraPath)
+			}
+		}
+
+		result.Items = append(result.Items, &ESignatureInstallParams{
+			CertificatePath:   certificatePath,
+			ContainerPath:     containerPath,
+			ExtraCertificates: extraCertificatePaths,
+		})
+	}
+
+	certificateKeys := make(map[string]string)
+	unpairedCertificates := make(map[string]*x509.Certificate)
+	for _, k := range sortedKeys(certificates) {
+		certificatePath := certificates[k].Path
+		certificateKeys[certificatePath] = k
+
+		container, ok := containers[k]
+		if !ok {
+			slog.Debug(fmt.Sprintf("Pair not found for %s, %v", k, certificatePath))
+			unpairedCertificates[certificatePath] = certificates[k].Certificate
 			continue
 		}
+		addPair(certificatePath, container.Path, otherCertificates[k])
+	}
 
-		containerPath, err := filepath.Rel(path, containerPublicKeys[k])
-		if err != nil {
-			slog.Debug(err.Error())
+	// Контейнер или pfx без отдельного .cer файла устанавливается с сертификатом из самого контейнера
+	for _, k := range sortedKeys(containers) {
+		if _, ok := certificates[k]; ok {
 			continue
 		}
 
-		result = append(result, &ESignatureInstallParams{
-			CertificatePath: certificatePath,
-			ContainerPath:   containerPath,
-		})
+		container := containers[k]
+		if !container.IsPfx {
+			if _, err := ExtractContainerCertificate(container.Path); err != nil {
+				slog.Debug(fmt.Sprintf("Pair not found for container %s, %v", k, container.Path))
+				result.Orphans = append(result.Orphans, newContainerOrphan(container.Path))
+				continue
+			}
+		}
+		addPair("", container.Path, nil)
+	}
+
+	for _, pfxPath := range pfxWithoutCertificate {
+		certificatePath := findSiblingCertificate(pfxPath, files.certificates)
+		if certificatePath == "" {
+			slog.Debug(fmt.Sprintf("Pair not found for pfx %s", pfxPath))
+			result.Orphans = append(result.Orphans, newContainerOrphan(pfxPath))
+			continue
+		}
+
+		if k, ok := certificateKeys[certificatePath]; ok {
+			if _, ok := containers[k]; ok {
+				slog.Debug(fmt.Sprintf("Pfx[%s] skipped, certificate[%s] already paired with container", pfxPath, certificatePath))
+				continue
+			}
+		}
+		delete(unpairedCertificates, certificatePath)
+		addPair(certificatePath, pfxPath, nil)
+	}
+
+	for _, certificatePath := range sortedKeys(unpairedCertificates) {
+		result.Orphans = append(result.Orphans, newCertificateOrphan(certificatePath, unpairedCertificates[certificatePath]))
 	}
-	slog.Debug(fmt.Sprintf("Found %d pair", len(result)))
+	slog.Debug(fmt.Sprintf("Found %d pair", len(result.Items)))
 
 	return result, nil
 }
@@ -0,0 +1,229 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const IGNORE_FILENAME = ".cpmassignore"
+
+// Папки root и crl содержат сертификаты УЦ и списки отзыва и исключаются из поиска пар,
+// правило можно отменить через "!root/" или "!crl/"
+var defaultIgnorePatterns = []string{"root/", CRL_FOLDER_NAME + "/"}
+
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// IgnoreMatcher реализует правила в стиле .gitignore: последнее совпавшее правило определяет результат
+type IgnoreMatcher struct {
+	rules   []ignoreRule
+	include []ignoreRule
+	exclude []ignoreRule
+}
+
+// globClassToRegexp переводит класс символов, начинающийся с pattern[start] == '[', и возвращает
+// индекс закрывающей скобки. "!" или "^" отрицают класс только в начале, "]" сразу после
+// открывающей скобки - обычный символ. Незакрытый или некорректный класс не переводится.
+func globClassToRegexp(pattern string, start int) (string, int, bool) {
+	i := start + 1
+	negate := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negate = true
+		i++
+	}
+
+	bodyStart := i
+	if i < len(pattern) && pattern[i] == ']' {
+		i++
+	}
+
+	end := strings.IndexByte(pattern[i:], ']')
+	if end < 0 {
+		return "", 0, false
+	}
+	end += i
+
+	var builder strings.Builder
+	builder.WriteString("[")
+	if negate {
+		builder.WriteString("^/")
+	}
+	for _, r := range pattern[bodyStart:end] {
+		if r == '-' {
+			builder.WriteRune(r)
+		} else {
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	builder.WriteString("]")
+
+	class := builder.String()
+	if _, err := regexp.Compile(class); err != nil {
+		return "", 0, false
+	}
+	return class, end, true
+}
+
+// globToRegexp переводит шаблон .gitignore в регулярное выражение для пути с разделителем "/"
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.HasPrefix(pattern, "/") || strings.Contains(strings.TrimPrefix(pattern, "**/"), "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var builder strings.Builder
+	builder.WriteString("^")
+	if !anchored {
+		builder.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			builder.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			builder.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			builder.WriteString(".*")
+			i++
+		case c == '*':
+			builder.WriteString("[^/]*")
+		case c == '?':
+			builder.WriteString("[^/]")
+		case c == '[':
+			class, end, ok := globClassToRegexp(pattern, i)
+			if !ok {
+				builder.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			builder.WriteString(class)
+			i = end
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			builder.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	builder.WriteString("$")
+
+	return regexp.Compile("(?i)" + builder.String())
+}
+
+func parseIgnoreRule(line string) (*ignoreRule, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	rule := &ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	pattern, err := globToRegexp(line)
+	if err != nil {
+		return nil, err
+	}
+	rule.pattern = pattern
+	return rule, nil
+}
+
+func parseIgnoreRules(patterns []string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	for _, pattern := range patterns {
+		rule, err := parseIgnoreRule(pattern)
+		if err != nil {
+			return rules, err
+		}
+
+		if rule != nil {
+			rules = append(rules, *rule)
+		}
+	}
+	return rules, nil
+}
+
+// NewIgnoreMatcher читает .cpmassignore из корня папки (если есть) и добавляет шаблоны include/exclude
+func NewIgnoreMatcher(root string, include []string, exclude []string) (*IgnoreMatcher, error) {
+	patterns := append([]string{}, defaultIgnorePatterns...)
+
+	file, err := os.Open(filepath.Join(root, IGNORE_FILENAME))
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			patterns = append(patterns, scanner.Text())
+		}
+		file.Close()
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	matcher := &IgnoreMatcher{}
+	if matcher.rules, err = parseIgnoreRules(patterns); err != nil {
+		return nil, err
+	}
+	if matcher.include, err = parseIgnoreRules(include); err != nil {
+		return nil, err
+	}
+	if matcher.exclude, err = parseIgnoreRules(exclude); err != nil {
+		return nil, err
+	}
+	return matcher, nil
+}
+
+func matchRules(rules []ignoreRule, relPath string, isDir bool) (matched bool, negate bool) {
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		if rule.pattern.MatchString(relPath) {
+			matched = true
+			negate = rule.negate
+		}
+	}
+	return matched, negate
+}
+
+// IsIgnored проверяет путь относительно корня поиска, relPath использует разделитель "/"
+func (m *IgnoreMatcher) IsIgnored(relPath string, isDir bool) bool {
+	if m == nil || relPath == "." || relPath == "" {
+		return false
+	}
+
+	if matched, negate := matchRules(m.exclude, relPath, isDir); matched && !negate {
+		return true
+	}
+
+	if matched, negate := matchRules(m.rules, relPath, isDir); matched && !negate {
+		return true
+	}
+
+	// Шаблоны include ограничивают только файлы, директории обходятся всегда
+	if !isDir && len(m.include) > 0 {
+		matched, negate := matchRules(m.include, relPath, isDir)
+		return !matched || negate
+	}
+	return false
+}
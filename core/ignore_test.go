@@ -0,0 +1,130 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.cer", "a.cer", true},
+		{"*.cer", "dir/a.CER", true},
+		{"*.cer", "a.cer.bak", false},
+		{"/*.cer", "dir/a.cer", false},
+		{"dir/*.cer", "dir/a.cer", true},
+		{"dir/*.cer", "other/dir/a.cer", false},
+		{"**/dir", "a/b/dir", true},
+		{"dir/**", "dir/a/b", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"?.cer", "a.cer", true},
+		{"?.cer", "ab.cer", false},
+		{"file[0-9].cer", "file1.cer", true},
+		{"file[0-9].cer", "filex.cer", false},
+		{"file[!0-9].cer", "filex.cer", true},
+		{"file[!0-9].cer", "file1.cer", false},
+		{"file[^0-9].cer", "filex.cer", true},
+		{"file[a!b].cer", "file!.cer", true},
+		{"file[a!b].cer", "file^.cer", false},
+		{"file[]].cer", "file].cer", true},
+		{"file[!]].cer", "filex.cer", true},
+		{"file[\\].cer", "file\\.cer", true},
+		{"file[.cer", "file[.cer", true},
+		{"file[].cer", "file[].cer", true},
+		{"file[z-a].cer", "file[z-a].cer", true},
+		{"file\\*.cer", "file*.cer", true},
+		{"file\\*.cer", "filex.cer", false},
+		{"a+b(1).cer", "a+b(1).cer", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.path, func(t *testing.T) {
+			pattern, err := globToRegexp(test.pattern)
+			if err != nil {
+				t.Fatalf("globToRegexp(%q) error: %s", test.pattern, err)
+			}
+
+			if match := pattern.MatchString(test.path); match != test.match {
+				t.Errorf("globToRegexp(%q).MatchString(%q) = %t, want %t", test.pattern, test.path, match, test.match)
+			}
+		})
+	}
+}
+
+func TestParseIgnoreRule(t *testing.T) {
+	tests := []struct {
+		line    string
+		empty   bool
+		negate  bool
+		dirOnly bool
+	}{
+		{"", true, false, false},
+		{"# comment", true, false, false},
+		{"*.bak  ", false, false, false},
+		{"!keep.cer", false, true, false},
+		{"\\!name.cer", false, false, false},
+		{"\\#name.cer", false, false, false},
+		{"old/", false, false, true},
+		{"!root/", false, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			rule, err := parseIgnoreRule(test.line)
+			if err != nil {
+				t.Fatalf("parseIgnoreRule(%q) error: %s", test.line, err)
+			}
+
+			if (rule == nil) != test.empty {
+				t.Fatalf("parseIgnoreRule(%q) = %v, want empty %t", test.line, rule, test.empty)
+			}
+
+			if rule != nil && (rule.negate != test.negate || rule.dirOnly != test.dirOnly) {
+				t.Errorf("parseIgnoreRule(%q) negate = %t, dirOnly = %t", test.line, rule.negate, rule.dirOnly)
+			}
+		})
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	root := t.TempDir()
+	content := "*.bak\nold/\n!old/keep.cer\n!crl/\n"
+	if err := os.WriteFile(filepath.Join(root, IGNORE_FILENAME), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	matcher, err := NewIgnoreMatcher(root, []string{"*.cer", "*.pfx"}, []string{"skip/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.cer", false, false},
+		{"a.bak", false, true},
+		{"a.txt", false, true},
+		{"dir", true, false},
+		{"old", true, true},
+		{"old/keep.cer", false, false},
+		{"root", true, true},
+		{"crl", true, false},
+		{"skip", true, true},
+		{"dir/skip", true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			if ignored := matcher.IsIgnored(test.path, test.isDir); ignored != test.ignored {
+				t.Errorf("IsIgnored(%q, %t) = %t, want %t", test.path, test.isDir, ignored, test.ignored)
+			}
+		})
+	}
+}
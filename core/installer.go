@@ -1,31 +1,46 @@
 package core
 
 import (
+	"crypto/x509"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 
 	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
 	"github.com/gocarina/gocsv"
 	"golang.org/x/exp/slog"
 )
 
-func InstallESignatureFromFile(certPath string, rootContainersFolder string, settings Settings) {
+// InstallESignatureFromFile устанавливает подписи из settings.json, data.csv или найденные в папке certs.
+// Возвращает ошибку чтения списка подписей или ErrCertificateCheckFail, если установка прервана проверкой с действием fail
+func InstallESignatureFromFile(certPath string, rootContainersFolder string, settings Settings) error {
 	items := []*ESignatureInstallParams{}
+	var orphans []*Orphan
 
 	if settings.Items != nil && len(*settings.Items) > 0 {
 		items = *settings.Items
 	} else if _, err := os.Stat("data.csv"); errors.Is(err, os.ErrNotExist) {
-		pair, err := FindDigitalSignaturePairs(certPath)
+		options := DiscoveryOptions{
+			DuplicatePolicy: DUPLICATE_POLICY_FOLDER,
+			Include:         settings.Args.Include,
+			Exclude:         settings.Args.Exclude,
+			CachePath:       GetDiscoveryCachePath(),
+			PfxPassword:     ResolvePfxPassword(settings.Default.PfxPassword, settings.Default.ContainerPin),
+		}
+		if settings.Args.DuplicatePolicy != nil {
+			options.DuplicatePolicy = *settings.Args.DuplicatePolicy
+		}
+
+		discovery, err := FindDigitalSignaturePairs(certPath, options)
 		if err != nil {
 			slog.Error(err.Error())
-			return
+			return err
 		}
-		items = pair
+		items = discovery.Items
+		orphans = discovery.Orphans
 	} else {
 		gocsv.SetCSVReader(func(in io.Reader) gocsv.CSVReader {
 			r := csv.NewReader(in)
@@ -37,46 +52,92 @@ func InstallESignatureFromFile(certPath string, rootContainersFolder string, set
 		in, err := os.Open("data.csv")
 		if err != nil {
 			slog.Error(err.Error())
-			return
+			return err
 		}
 		defer in.Close()
 
 		if err := gocsv.UnmarshalFile(in, &items); err != nil {
 			slog.Error(err.Error())
-			return
+			return err
 		}
 	}
 
+	var installErr error
 	slog.Info(fmt.Sprintf("Количество устанавливаемых ЭП: %d", len(items)))
 	for _, installParams := range items {
 		fmt.Println()
-		containerPath := filepath.Join(certPath, installParams.ContainerPath)
-		installParams.ContainerPath = containerPath
+		if !filepath.IsAbs(installParams.ContainerPath) {
+			installParams.ContainerPath = filepath.Join(certPath, installParams.ContainerPath)
+		}
+
+		if installParams.CertificatePath != "" && !filepath.IsAbs(installParams.CertificatePath) {
+			installParams.CertificatePath = filepath.Join(certPath, installParams.CertificatePath)
+		}
+
+		if err := ResolveInstallPaths(installParams); err != nil {
+			slog.Error(err.Error())
+			continue
+		}
 
 		if installParams.ContainerName == "" && settings.Default.NamePattern != nil {
 			installParams.ContainerName = *settings.Default.NamePattern
 		}
 
-		if filepath.Ext(installParams.ContainerPath) == ".pfx" && installParams.PfxPassword == nil && settings.Default.PfxPassword != nil {
+		if IsPfxFile(installParams.ContainerPath) && installParams.PfxPassword == nil && settings.Default.PfxPassword != nil {
 			installParams.PfxPassword = settings.Default.PfxPassword
 		}
 
-		certificatePath := filepath.Join(certPath, installParams.CertificatePath)
-		installParams.CertificatePath = certificatePath
-
 		if installParams.Exportable == nil {
 			installParams.Exportable = settings.Default.Exportable
 		}
+		if installParams.Exportable == nil {
+			exportable := false
+			installParams.Exportable = &exportable
+		}
+
+		if installParams.InstallAllCertificates == nil {
+			installParams.InstallAllCertificates = settings.Default.InstallAllCertificates
+		}
 
-		InstallESignature(rootContainersFolder, installParams)
+		if installParams.Store == "" && settings.Default.Store != nil {
+			installParams.Store = *settings.Default.Store
+		}
+
+		if installParams.NameCollisionPolicy == "" && settings.Default.NameCollisionPolicy != nil {
+			installParams.NameCollisionPolicy = *settings.Default.NameCollisionPolicy
+		}
+
+		if installParams.ContainerPin == nil {
+			installParams.ContainerPin = settings.Default.ContainerPin
+		}
+
+		if installParams.NewContainerPin == nil {
+			installParams.NewContainerPin = settings.Default.NewContainerPin
+		}
+
+		if installParams.ClearContainerPin == nil {
+			installParams.ClearContainerPin = settings.Default.ClearContainerPin
+		}
+
+		for i, extraCertificatePath := range installParams.ExtraCertificates {
+			if !filepath.IsAbs(extraCertificatePath) {
+				installParams.ExtraCertificates[i] = filepath.Join(certPath, extraCertificatePath)
+			}
+		}
+
+		err := InstallESignature(rootContainersFolder, installParams)
+		if errors.Is(err, ErrCertificateCheckFail) {
+			installErr = err
+			break
+		}
 	}
+
+	PrintOrphansReport(orphans)
+	PrintRevocationReport()
+	return installErr
 }
 
 func InstallESignatureCLI(certPath string, rootContainersFolder string, installParams *ESignatureInstallParams, waitFlag bool) error {
-	if installParams.CertificatePath == "" {
-		slog.Error("Не указан путь до сертификата, используйте флаг -cert для указания пути")
-		return errors.New("certificate not set")
-	}
 	if installParams.ContainerPath == "" {
 		slog.Error("Не указан путь до контейнера, используйте флаг -cont для указания пути")
 		return errors.New("container not set")
@@ -89,19 +150,64 @@ func InstallESignatureCLI(certPath string, rootContainersFolder string, installP
 	}
 	installParams.ContainerPath = containerPath
 
-	certificatePath, err := GetFilePath(installParams.CertificatePath, certPath)
-	if err != nil {
+	if installParams.CertificatePath != "" {
+		certificatePath, err := GetFilePath(installParams.CertificatePath, certPath)
+		if err != nil {
+			slog.Error(err.Error())
+			return err
+		}
+		installParams.CertificatePath = certificatePath
+	}
+
+	if err := ResolveInstallPaths(installParams); err != nil {
 		slog.Error(err.Error())
 		return err
 	}
-	installParams.CertificatePath = certificatePath
 
-	InstallESignature(rootContainersFolder, installParams)
+	err = InstallESignature(rootContainersFolder, installParams)
 	if waitFlag {
+		CleanupTempFolder()
 		fmt.Print("\n\n\nУстановка сертификатов завершена, нажмите Enter:")
 		fmt.Scanln()
 	}
-	return nil
+	return err
+}
+
+// installRootFile устанавливает отсутствующие в хранилищах сертификаты из .cer или p7b файла.
+// seen - отпечатки сертификатов, уже обработанных из других файлов папки root.
+func installRootFile(path string, certificates []*x509.Certificate, seen map[string]bool) (int, int) {
+	filename := filepath.Base(path)
+	installed, existing := 0, 0
+
+	for _, certificate := range certificates {
+		thumbprint := cades.GetThumbprint(certificate)
+		if seen[thumbprint] {
+			existing++
+			continue
+		}
+		seen[thumbprint] = true
+
+		store := CertificateStore(certificate)
+		if exists, _ := IsCertificateExists(thumbprint, store); exists {
+			slog.Debug(fmt.Sprintf("Certificate[%s] from %s exists in store[%s]", certificate.Subject.CommonName, path, store))
+			existing++
+			continue
+		}
+
+		err := installCertificateToStore(path, certificate, store)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("Cant install certificate[%s] from %s, error: %s", certificate.Subject.CommonName, path, err.Error()))
+			continue
+		}
+
+		installed++
+		if store == CERTIFICATE_STORE_ROOT {
+			slog.Info(fmt.Sprintf("Корневой сертификат[%s] установлен из %s", certificate.Subject.CommonName, filename))
+		} else {
+			slog.Info(fmt.Sprintf("Промежуточный сертификат[%s] установлен из %s", certificate.Subject.CommonName, filename))
+		}
+	}
+	return installed, existing
 }
 
 func InstallRootCertificates(certsFolderPath string) {
@@ -117,6 +223,8 @@ func InstallRootCertificates(certsFolderPath string) {
 		return
 	}
 
+	seen := make(map[string]bool)
+	totalInstalled, totalExisting := 0, 0
 	for _, entity := range folderEntity {
 		if entity.IsDir() {
 			continue
@@ -125,31 +233,26 @@ func InstallRootCertificates(certsFolderPath string) {
 		filename := entity.Name()
 		path := filepath.Join(rootFolder, filename)
 
-		if strings.HasSuffix(filename, ".p7b") {
-			err = InstallRootCertificate(path)
-			if err == nil {
-				slog.Info(fmt.Sprintf("Корневой сертификат[%s] установлен", filename))
-			}
+		if err := CheckManifestIntegrity(path); err != nil {
+			slog.Error(fmt.Sprintf("Корневой сертификат[%s] пропущен: %s", filename, err))
 			continue
 		}
 
-		if strings.HasSuffix(filename, ".cer") {
-			thumbprint, err := cades.GetCertificateThumbprintFromFile(path)
-			if err != nil {
-				slog.Debug(fmt.Sprintf("Cant get thumbprint from file[%s], error: %s", path, err.Error()))
-				continue
-			}
-
-			certExists, _ := IsCertificateExists(thumbprint, "uRoot")
+		certificates, err := loadRootFile(path)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("File[%s] is not a certificate, error: %s", path, err.Error()))
+			continue
+		}
 
-			if !certExists {
-				err = InstallRootCertificate(path)
-				if err == nil {
-					slog.Info(fmt.Sprintf("Корневой сертификат[%s] установлен", filename))
-				}
-			} else {
-				slog.Debug(fmt.Sprintf("Root certificate[%s] exists in store[uRoot]", path))
-			}
+		installed, existing := installRootFile(path, certificates, seen)
+		if IsPkcs7File(path) {
+			slog.Info(fmt.Sprintf("Цепочка[%s]: новых сертификатов %d, уже установлено %d", filename, installed, existing))
 		}
+		totalInstalled += installed
+		totalExisting += existing
+	}
+
+	if totalInstalled+totalExisting > 0 {
+		slog.Info(fmt.Sprintf("Сертификаты УЦ из папки root: новых %d, уже установлено %d", totalInstalled, totalExisting))
 	}
 }
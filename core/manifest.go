@@ -0,0 +1,319 @@
+package core
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	MANIFEST_JSON_FILENAME       = "manifest.json"
+	MANIFEST_SHA256SUMS_FILENAME = "SHA256SUMS"
+
+	MANIFEST_POLICY_SKIP  = "skip"
+	MANIFEST_POLICY_ABORT = "abort"
+)
+
+var (
+	ErrManifestMismatch = errors.New("files do not match the manifest")
+	ErrManifestPolicy   = errors.New("unknown manifest policy")
+)
+
+// Пути (абсолютные) файлов, не прошедших проверку по манифесту, и причина
+var manifestBrokenFiles map[string]string
+
+type Manifest struct {
+	Algorithm string            `json:"algorithm"`
+	Files     map[string]string `json:"files"`
+}
+
+type ManifestReport struct {
+	Path       string
+	Mismatched map[string]string
+	Missing    []string
+	Unlisted   []string
+}
+
+func (r *ManifestReport) IsValid() bool {
+	return len(r.Mismatched) == 0 && len(r.Missing) == 0 && len(r.Unlisted) == 0
+}
+
+func isManifestFile(name string) bool {
+	return name == MANIFEST_JSON_FILENAME || name == MANIFEST_SHA256SUMS_FILENAME
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func collectManifestFiles(certsPath string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(certsPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || isManifestFile(info.Name()) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(certsPath, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(relPath))
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func CreateManifest(certsPath string) (*Manifest, error) {
+	manifest := &Manifest{Algorithm: "sha256", Files: make(map[string]string)}
+
+	files, err := collectManifestFiles(certsPath)
+	if err != nil {
+		return manifest, err
+	}
+
+	for _, file := range files {
+		hash, err := hashFile(filepath.Join(certsPath, filepath.FromSlash(file)))
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Files[file] = hash
+	}
+	return manifest, nil
+}
+
+// SaveManifest сохраняет манифест в формате manifest.json или SHA256SUMS в зависимости от имени файла
+func SaveManifest(manifest *Manifest, path string) error {
+	if filepath.Base(path) == MANIFEST_SHA256SUMS_FILENAME {
+		files := make([]string, 0, len(manifest.Files))
+		for file := range manifest.Files {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		var builder strings.Builder
+		for _, file := range files {
+			builder.WriteString(fmt.Sprintf("%s  %s\n", manifest.Files[file], file))
+		}
+		return os.WriteFile(path, []byte(builder.String()), 0644)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func parseSha256Sums(data io.Reader) (*Manifest, error) {
+	manifest := &Manifest{Algorithm: "sha256", Files: make(map[string]string)}
+
+	scanner := bufio.NewScanner(data)
+	for scanner.Scan() {
+		// Пробелы в конце строки могут быть частью имени файла, отбрасывается только \r
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Формат sha256sum: "<hash>  <file>" для текстового режима или "<hash> *<file>" для бинарного
+		hash, file, found := strings.Cut(line, " ")
+		if !found || len(hash) != sha256.Size*2 {
+			return manifest, fmt.Errorf("invalid SHA256SUMS line: %s", line)
+		}
+
+		if strings.HasPrefix(file, " ") || strings.HasPrefix(file, "*") {
+			file = file[1:]
+		}
+
+		if file == "" {
+			return manifest, fmt.Errorf("invalid SHA256SUMS line: %s", line)
+		}
+		manifest.Files[filepath.ToSlash(file)] = strings.ToLower(hash)
+	}
+	return manifest, scanner.Err()
+}
+
+// ParseManifestPolicy проверяет политику манифеста, регистр букв не учитывается
+func ParseManifestPolicy(policy string) (string, error) {
+	switch value := strings.ToLower(strings.TrimSpace(policy)); value {
+	case MANIFEST_POLICY_SKIP, MANIFEST_POLICY_ABORT:
+		return value, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrManifestPolicy, policy)
+	}
+}
+
+// validateManifestPaths отклоняет записи манифеста с путями вне папки с сертификатами
+func validateManifestPaths(manifest *Manifest) error {
+	for file := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(file)) || strings.HasPrefix(file, "/") {
+			return fmt.Errorf("path outside of certs folder in manifest: %s", file)
+		}
+	}
+	return nil
+}
+
+// LoadManifest ищет manifest.json или SHA256SUMS в папке с сертификатами
+func LoadManifest(certsPath string) (*Manifest, string, error) {
+	jsonPath := filepath.Join(certsPath, MANIFEST_JSON_FILENAME)
+	if data, err := os.ReadFile(jsonPath); err == nil {
+		var manifest Manifest
+		err = json.Unmarshal(data, &manifest)
+		if err == nil && manifest.Algorithm != "" && strings.ToLower(manifest.Algorithm) != "sha256" {
+			err = fmt.Errorf("unsupported manifest algorithm: %s", manifest.Algorithm)
+		}
+		if err == nil {
+			err = validateManifestPaths(&manifest)
+		}
+		return &manifest, jsonPath, err
+	}
+
+	sumsPath := filepath.Join(certsPath, MANIFEST_SHA256SUMS_FILENAME)
+	file, err := os.Open(sumsPath)
+	if err != nil {
+		return nil, "", os.ErrNotExist
+	}
+	defer file.Close()
+
+	manifest, err := parseSha256Sums(file)
+	if err == nil {
+		err = validateManifestPaths(manifest)
+	}
+	return manifest, sumsPath, err
+}
+
+func VerifyManifest(certsPath string, manifest *Manifest) (*ManifestReport, error) {
+	report := &ManifestReport{Mismatched: make(map[string]string)}
+
+	files, err := collectManifestFiles(certsPath)
+	if err != nil {
+		return report, err
+	}
+
+	listed := make(map[string]bool)
+	for file := range manifest.Files {
+		listed[file] = true
+	}
+
+	for _, file := range files {
+		if !listed[file] {
+			report.Unlisted = append(report.Unlisted, file)
+		}
+	}
+
+	for file, expectedHash := range manifest.Files {
+		path := filepath.Join(certsPath, filepath.FromSlash(file))
+		hash, err := hashFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			report.Missing = append(report.Missing, file)
+			continue
+		} else if err != nil {
+			report.Mismatched[file] = err.Error()
+			continue
+		}
+
+		if !strings.EqualFold(hash, expectedHash) {
+			report.Mismatched[file] = fmt.Sprintf("ожидалось %s, получено %s", expectedHash, hash)
+		}
+	}
+	sort.Strings(report.Missing)
+	return report, nil
+}
+
+// CheckCertsManifest проверяет файлы в папке с сертификатами по манифесту, если он существует.
+// Поврежденные файлы и файлы, отсутствующие в манифесте, запоминаются и пропускаются при установке,
+// при политике abort возвращается ошибка.
+func CheckCertsManifest(certsPath string, policy string) error {
+	manifestBrokenFiles = nil
+
+	manifestPolicy, err := ParseManifestPolicy(policy)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Неизвестная политика манифеста[%s], допустимо skip или abort", policy))
+		return err
+	}
+
+	manifest, manifestPath, err := LoadManifest(certsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Debug(fmt.Sprintf("Manifest not found in %s", certsPath))
+		return nil
+	} else if err != nil {
+		slog.Error(fmt.Sprintf("Не удалось прочитать манифест[%s]: %s", manifestPath, err))
+		return err
+	}
+
+	report, err := VerifyManifest(certsPath, manifest)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Не удалось проверить файлы по манифесту[%s]: %s", manifestPath, err))
+		return err
+	}
+	report.Path = manifestPath
+
+	if report.IsValid() {
+		slog.Info(fmt.Sprintf("Проверка целостности файлов по манифесту пройдена (%d файлов)", len(manifest.Files)))
+		return nil
+	}
+
+	manifestBrokenFiles = make(map[string]string)
+	for _, file := range report.Missing {
+		slog.Error(fmt.Sprintf("Файл[%s] из манифеста не найден", file))
+		manifestBrokenFiles[filepath.Join(certsPath, filepath.FromSlash(file))] = "файл не найден"
+	}
+
+	for _, file := range report.Unlisted {
+		slog.Error(fmt.Sprintf("Файл[%s] отсутствует в манифесте, целостность не проверена", file))
+		manifestBrokenFiles[filepath.Join(certsPath, filepath.FromSlash(file))] = "файл отсутствует в манифесте"
+	}
+
+	for file, reason := range report.Mismatched {
+		slog.Error(fmt.Sprintf("Файл[%s] не совпадает с манифестом: %s", file, reason))
+		manifestBrokenFiles[filepath.Join(certsPath, filepath.FromSlash(file))] = reason
+	}
+
+	if manifestPolicy == MANIFEST_POLICY_ABORT {
+		slog.Error("Установка прервана: файлы не совпадают с манифестом")
+		return ErrManifestMismatch
+	}
+
+	slog.Warn("Подписи с поврежденными файлами будут пропущены")
+	return nil
+}
+
+// CheckManifestIntegrity возвращает ошибку, если файл или любой файл в директории не прошел проверку по манифесту
+func CheckManifestIntegrity(path string) error {
+	if len(manifestBrokenFiles) == 0 {
+		return nil
+	}
+
+	path = filepath.Clean(path)
+	for brokenPath, reason := range manifestBrokenFiles {
+		if brokenPath == path || strings.HasPrefix(brokenPath, path+string(filepath.Separator)) {
+			return fmt.Errorf("файл[%s] не прошел проверку по манифесту (%s): %w", brokenPath, reason, ErrManifestMismatch)
+		}
+	}
+	return nil
+}
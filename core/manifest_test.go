@@ -0,0 +1,203 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testManifestHash = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func writeTestCertsFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, content)
+}
+
+// writeTestCerts создает папку с сертификатами и манифестом в формате manifestName
+func writeTestCerts(t *testing.T, manifestName string) string {
+	t.Helper()
+
+	certsPath := t.TempDir()
+	writeTestCertsFile(t, filepath.Join(certsPath, "Иванов", "Иванов.cer"), "hello")
+	writeTestCertsFile(t, filepath.Join(certsPath, "root.cer"), "root")
+
+	manifest, err := CreateManifest(certsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveManifest(manifest, filepath.Join(certsPath, manifestName)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { manifestBrokenFiles = nil })
+	return certsPath
+}
+
+func TestCreateManifest(t *testing.T) {
+	for _, manifestName := range []string{MANIFEST_JSON_FILENAME, MANIFEST_SHA256SUMS_FILENAME} {
+		t.Run(manifestName, func(t *testing.T) {
+			certsPath := writeTestCerts(t, manifestName)
+
+			manifest, path, err := LoadManifest(certsPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if filepath.Base(path) != manifestName {
+				t.Errorf("LoadManifest() path = %s, want %s", path, manifestName)
+			}
+
+			if len(manifest.Files) != 2 || manifest.Files["Иванов/Иванов.cer"] != testManifestHash {
+				t.Errorf("LoadManifest() files = %v", manifest.Files)
+			}
+
+			report, err := VerifyManifest(certsPath, manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !report.IsValid() {
+				t.Errorf("VerifyManifest() report is not valid: %+v", report)
+			}
+		})
+	}
+}
+
+func TestParseSha256Sums(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		file  string
+		valid bool
+	}{
+		{"текстовый режим", testManifestHash + "  Иванов/Иванов.cer", "Иванов/Иванов.cer", true},
+		{"бинарный режим", testManifestHash + " *Иванов/Иванов.cer", "Иванов/Иванов.cer", true},
+		{"пробел в имени файла", testManifestHash + "  Иванов И.И.cer", "Иванов И.И.cer", true},
+		{"перевод строки Windows", testManifestHash + " *root.cer\r", "root.cer", true},
+		{"хеш в верхнем регистре", strings.ToUpper(testManifestHash) + "  root.cer", "root.cer", true},
+		{"нет имени файла", testManifestHash, "", false},
+		{"пустое имя файла", testManifestHash + " *", "", false},
+		{"неверная длина хеша", "abcdef  root.cer", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manifest, err := parseSha256Sums(strings.NewReader("# comment\n\n" + test.line + "\n"))
+			if !test.valid {
+				if err == nil {
+					t.Errorf("parseSha256Sums(%q) accepted invalid line", test.line)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := map[string]string{test.file: testManifestHash}
+			if !reflect.DeepEqual(manifest.Files, expected) {
+				t.Errorf("parseSha256Sums(%q) = %v, want %v", test.line, manifest.Files, expected)
+			}
+		})
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	certsPath := writeTestCerts(t, MANIFEST_SHA256SUMS_FILENAME)
+	writeTestCertsFile(t, filepath.Join(certsPath, "Иванов", "Иванов.cer"), "changed")
+	writeTestCertsFile(t, filepath.Join(certsPath, "Петров", "Петров.cer"), "new")
+	if err := os.Remove(filepath.Join(certsPath, "root.cer")); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, _, err := LoadManifest(certsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyManifest(certsPath, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.IsValid() {
+		t.Fatal("VerifyManifest() report is valid")
+	}
+
+	if _, ok := report.Mismatched["Иванов/Иванов.cer"]; !ok || len(report.Mismatched) != 1 {
+		t.Errorf("VerifyManifest() mismatched = %v", report.Mismatched)
+	}
+
+	if !reflect.DeepEqual(report.Missing, []string{"root.cer"}) {
+		t.Errorf("VerifyManifest() missing = %v", report.Missing)
+	}
+
+	if !reflect.DeepEqual(report.Unlisted, []string{"Петров/Петров.cer"}) {
+		t.Errorf("VerifyManifest() unlisted = %v", report.Unlisted)
+	}
+}
+
+func TestCheckCertsManifest(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(certsPath string)
+		broken string
+	}{
+		{"файлы совпадают", func(certsPath string) {}, ""},
+		{"файл изменен", func(certsPath string) {
+			writeTestCertsFile(t, filepath.Join(certsPath, "Иванов", "Иванов.cer"), "changed")
+		}, "Иванов"},
+		{"файл удален", func(certsPath string) {
+			os.Remove(filepath.Join(certsPath, "root.cer"))
+		}, "root.cer"},
+		{"файл отсутствует в манифесте", func(certsPath string) {
+			writeTestCertsFile(t, filepath.Join(certsPath, "Петров", "Петров.cer"), "new")
+		}, "Петров"},
+	}
+
+	for _, test := range tests {
+		for _, policy := range []string{MANIFEST_POLICY_SKIP, MANIFEST_POLICY_ABORT} {
+			t.Run(test.name+" "+policy, func(t *testing.T) {
+				certsPath := writeTestCerts(t, MANIFEST_JSON_FILENAME)
+				test.modify(certsPath)
+
+				err := CheckCertsManifest(certsPath, policy)
+				if test.broken == "" {
+					if err != nil {
+						t.Fatalf("CheckCertsManifest() error = %v", err)
+					}
+					return
+				}
+
+				if policy == MANIFEST_POLICY_ABORT {
+					if !errors.Is(err, ErrManifestMismatch) {
+						t.Errorf("CheckCertsManifest() error = %v, want ErrManifestMismatch", err)
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("CheckCertsManifest() error = %v", err)
+				}
+
+				if err := CheckManifestIntegrity(filepath.Join(certsPath, test.broken)); !errors.Is(err, ErrManifestMismatch) {
+					t.Errorf("CheckManifestIntegrity(%s) error = %v, want ErrManifestMismatch", test.broken, err)
+				}
+			})
+		}
+	}
+
+	t.Run("неизвестная политика", func(t *testing.T) {
+		if err := CheckCertsManifest(t.TempDir(), "ignore"); !errors.Is(err, ErrManifestPolicy) {
+			t.Errorf("CheckCertsManifest() error = %v, want ErrManifestPolicy", err)
+		}
+	})
+}
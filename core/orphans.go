@@ -0,0 +1,69 @@
+package core
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	ORPHAN_CERTIFICATE = "certificate"
+	ORPHAN_CONTAINER   = "container"
+)
+
+type Orphan struct {
+	Kind          string    `json:"kind"`
+	Path          string    `json:"path"`
+	Owner         string    `json:"owner,omitempty"`
+	NotAfter      time.Time `json:"notAfter,omitempty"`
+	ContainerName string    `json:"containerName,omitempty"`
+}
+
+type DiscoveryResult struct {
+	Items   []*ESignatureInstallParams `json:"items"`
+	Orphans []*Orphan                  `json:"orphans,omitempty"`
+}
+
+func newCertificateOrphan(path string, certificate *x509.Certificate) *Orphan {
+	orphan := &Orphan{Kind: ORPHAN_CERTIFICATE, Path: path, NotAfter: certificate.NotAfter}
+
+	gostCertificate, err := cades.ParseGostCertificate(certificate)
+	if err == nil {
+		orphan.Owner = gostCertificate.Subject["common_name"]
+	}
+	return orphan
+}
+
+func newContainerOrphan(path string) *Orphan {
+	return &Orphan{Kind: ORPHAN_CONTAINER, Path: path, ContainerName: ReadContainerName(path)}
+}
+
+// PrintOrphansReport выводит список сертификатов и контейнеров, для которых не найдена пара
+func PrintOrphansReport(orphans []*Orphan) {
+	if len(orphans) == 0 {
+		return
+	}
+
+	var lines []string
+	for _, orphan := range orphans {
+		switch orphan.Kind {
+		case ORPHAN_CERTIFICATE:
+			lines = append(lines, fmt.Sprintf(
+				"  Сертификат[%s] без контейнера (Владелец: %s, действителен до %s)",
+				orphan.Path, orphan.Owner, orphan.NotAfter.Format("02.01.2006"),
+			))
+		case ORPHAN_CONTAINER:
+			lines = append(lines, fmt.Sprintf(
+				"  Контейнер[%s] без сертификата (Имя контейнера: %s)",
+				orphan.Path, orphan.ContainerName,
+			))
+		}
+	}
+
+	fmt.Println()
+	slog.Warn(fmt.Sprintf("Не установлены подписи без пары (%d):\n%s", len(orphans), strings.Join(lines, "\n")))
+}
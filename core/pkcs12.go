@@ -0,0 +1,394 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/exp/slog"
+)
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidCertBag                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509CertType             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+
+	oidPbeWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBES2                         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHmacWithSHA1                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHmacWithSHA256                = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHmacWithSHA512                = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC                     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC                     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC                     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+var (
+	ErrPfxCertificateNotFound = errors.New("certificate not found in pfx")
+	// Сертификаты зашифрованы неподдерживаемым алгоритмом (ГОСТ 28147-89, RC2) или пароль не подошел
+	ErrPfxEncrypted = errors.New("pfx certificates are encrypted")
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe pfxContentInfo
+	MacData  asn1.RawValue `asn1:"optional"`
+}
+
+type pfxContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type pfxSafeBag struct {
+	Id         asn1.ObjectIdentifier
+	Value      asn1.RawValue `asn1:"tag:0,explicit"`
+	Attributes asn1.RawValue `asn1:"optional"`
+}
+
+type pfxCertBag struct {
+	Id   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pfxEncryptedData struct {
+	Version              int
+	EncryptedContentInfo pfxEncryptedContentInfo
+}
+
+type pfxEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type pfxPbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pfxPbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pfxPbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	Prf        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+func IsPfxFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".pfx" || ext == ".p12"
+}
+
+func unmarshalPfxData(info pfxContentInfo, out any) error {
+	if !info.ContentType.Equal(oidDataContentType) {
+		return ErrPfxCertificateNotFound
+	}
+
+	var data []byte
+	_, err := asn1.Unmarshal(info.Content.Bytes, &data)
+	if err != nil {
+		return err
+	}
+
+	_, err = asn1.Unmarshal(data, out)
+	return err
+}
+
+// pkcs12Kdf - функция выработки ключа из RFC 7292 (приложение B) на SHA-1, password в BMPString
+func pkcs12Kdf(password []byte, salt []byte, iterations int, id byte, size int) []byte {
+	const u, v = sha1.Size, 64
+
+	fill := func(data []byte) []byte {
+		if len(data) == 0 {
+			return nil
+		}
+		result := make([]byte, v*((len(data)+v-1)/v))
+		for i := range result {
+			result[i] = data[i%len(data)]
+		}
+		return result
+	}
+
+	diversifier := bytes.Repeat([]byte{id}, v)
+	input := append(fill(salt), fill(password)...)
+
+	var result []byte
+	for len(result) < size {
+		digest := sha1.Sum(append(diversifier, input...))
+		a := digest[:]
+		for i := 1; i < iterations; i++ {
+			digest = sha1.Sum(a)
+			a = digest[:]
+		}
+		result = append(result, a...)
+
+		// Блоки входа увеличиваются на B + 1 по модулю 2^(v*8)
+		b := fill(a)
+		for j := 0; j < len(input); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[j+k]) + int(b[k]) + carry
+				input[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return result[:size]
+}
+
+// bmpPassword кодирует пароль в BMPString с завершающим нулем, как требует PKCS#12
+func bmpPassword(password string) []byte {
+	var result []byte
+	for _, r := range utf16.Encode([]rune(password)) {
+		result = append(result, byte(r>>8), byte(r))
+	}
+	return append(result, 0, 0)
+}
+
+func pbkdf2Hash(prf pkix.AlgorithmIdentifier) (func() hash.Hash, bool) {
+	switch {
+	case len(prf.Algorithm) == 0 || prf.Algorithm.Equal(oidHmacWithSHA1):
+		return sha1.New, true
+	case prf.Algorithm.Equal(oidHmacWithSHA256):
+		return sha256.New, true
+	case prf.Algorithm.Equal(oidHmacWithSHA512):
+		return sha512.New, true
+	}
+	return nil, false
+}
+
+// pbes2Cipher вырабатывает ключ по PBKDF2 и возвращает блочный шифр с вектором инициализации
+func pbes2Cipher(parameters []byte, password string) (cipher.Block, []byte, error) {
+	var params pfxPbes2Params
+	if _, err := asn1.Unmarshal(parameters, &params); err != nil {
+		return nil, nil, err
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, nil, ErrPfxEncrypted
+	}
+
+	var kdf pfxPbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, nil, err
+	}
+
+	newHash, ok := pbkdf2Hash(kdf.Prf)
+	if !ok {
+		return nil, nil, ErrPfxEncrypted
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, err
+	}
+
+	scheme := params.EncryptionScheme.Algorithm
+	var keyLength int
+	switch {
+	case scheme.Equal(oidAES128CBC):
+		keyLength = 16
+	case scheme.Equal(oidAES192CBC):
+		keyLength = 24
+	case scheme.Equal(oidAES256CBC):
+		keyLength = 32
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLength = 24
+	default:
+		return nil, nil, ErrPfxEncrypted
+	}
+
+	key := pbkdf2.Key([]byte(password), kdf.Salt, kdf.Iterations, keyLength, newHash)
+	var block cipher.Block
+	var err error
+	if scheme.Equal(oidDESEDE3CBC) {
+		block, err = des.NewTripleDESCipher(key)
+	} else {
+		block, err = aes.NewCipher(key)
+	}
+	return block, iv, err
+}
+
+// decryptPfxData расшифровывает раздел pfx, зашифрованный паролем по PBES2 (AES, 3DES)
+// или pbeWithSHAAnd3-KeyTripleDES-CBC. Для остальных алгоритмов возвращается ErrPfxEncrypted.
+func decryptPfxData(info pfxContentInfo, password string) ([]byte, error) {
+	var encryptedData pfxEncryptedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &encryptedData); err != nil {
+		return nil, err
+	}
+
+	content := encryptedData.EncryptedContentInfo
+	algorithm := content.ContentEncryptionAlgorithm
+
+	var block cipher.Block
+	var iv []byte
+	switch {
+	case algorithm.Algorithm.Equal(oidPbeWithSHAAnd3KeyTripleDESCBC):
+		var params pfxPbeParams
+		if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+
+		bmp := bmpPassword(password)
+		key := pkcs12Kdf(bmp, params.Salt, params.Iterations, 1, 24)
+		iv = pkcs12Kdf(bmp, params.Salt, params.Iterations, 2, des.BlockSize)
+
+		var err error
+		if block, err = des.NewTripleDESCipher(key); err != nil {
+			return nil, err
+		}
+	case algorithm.Algorithm.Equal(oidPBES2):
+		var err error
+		if block, iv, err = pbes2Cipher(algorithm.Parameters.FullBytes, password); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrPfxEncrypted
+	}
+
+	data := content.EncryptedContent
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, ErrPfxEncrypted
+	}
+
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, data)
+
+	// Неверный пароль обычно дает некорректное дополнение PKCS#7
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.Equal(decrypted[len(decrypted)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrPfxEncrypted
+	}
+	return decrypted[:len(decrypted)-padding], nil
+}
+
+// ExtractCertificatesFromPfx возвращает сертификаты из pfx файла. Разделы, зашифрованные паролем,
+// расшифровываются паролем password (PBES2 с AES или 3DES, PKCS#12 3DES), разделы с другими
+// алгоритмами (ГОСТ 28147-89, RC2) пропускаются. Если сертификаты есть только в таких разделах,
+// возвращается ErrPfxEncrypted.
+func ExtractCertificatesFromPfx(data []byte, password string) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+
+	var pfx pfxPdu
+	if _, err := asn1.Unmarshal(data, &pfx); err != nil {
+		return certificates, err
+	}
+
+	var authenticatedSafe []pfxContentInfo
+	if err := unmarshalPfxData(pfx.AuthSafe, &authenticatedSafe); err != nil {
+		return certificates, err
+	}
+
+	encrypted := false
+	for _, info := range authenticatedSafe {
+		var bags []pfxSafeBag
+		if info.ContentType.Equal(oidEncryptedDataContentType) {
+			decrypted, err := decryptPfxData(info, password)
+			if err == nil {
+				_, err = asn1.Unmarshal(decrypted, &bags)
+			}
+
+			if err != nil {
+				encrypted = true
+				continue
+			}
+		} else if err := unmarshalPfxData(info, &bags); err != nil {
+			continue
+		}
+
+		for _, bag := range bags {
+			if !bag.Id.Equal(oidCertBag) {
+				continue
+			}
+
+			var certBag pfxCertBag
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &certBag); err != nil {
+				continue
+			}
+
+			if !certBag.Id.Equal(oidX509CertType) {
+				continue
+			}
+
+			certificate, err := x509.ParseCertificate(certBag.Data)
+			if err != nil {
+				continue
+			}
+			certificates = append(certificates, certificate)
+		}
+	}
+
+	if len(certificates) == 0 && encrypted {
+		return certificates, ErrPfxEncrypted
+	} else if len(certificates) == 0 {
+		return certificates, ErrPfxCertificateNotFound
+	}
+	return certificates, nil
+}
+
+// ResolvePfxPassword возвращает пароль для чтения зашифрованных сертификатов pfx без запроса ввода:
+// пароль pfx, а если он не задан - PIN-код контейнера (certmgr использует его как пароль pfx)
+func ResolvePfxPassword(pfxPassword *string, containerPin *string) string {
+	reference := pfxPassword
+	if reference == nil {
+		reference = containerPin
+	}
+
+	if reference == nil || IsPromptSecret(*reference) {
+		return ""
+	}
+
+	password, err := ResolveSecret(*reference, "pfx")
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Cant resolve pfx password: %s", err))
+		return ""
+	}
+	return password
+}
+
+// GetPfxCertificate возвращает сертификат владельца ключа (не УЦ) из pfx файла
+func GetPfxCertificate(path string, password string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePfxCertificate(data, password)
+}
+
+// ParsePfxCertificate возвращает сертификат владельца ключа (не УЦ) из содержимого pfx файла
+func ParsePfxCertificate(data []byte, password string) (*x509.Certificate, error) {
+	certificates, err := ExtractCertificatesFromPfx(data, password)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, certificate := range certificates {
+		if !certificate.IsCA && certificate.PublicKey == nil {
+			return certificate, nil
+		}
+	}
+	return nil, ErrPfxCertificateNotFound
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Файлы testdata/*.pfx созданы openssl из testdata/test.pem с паролем "pa ss"
+const testPfxPassword = "pa ss"
+
+func readTestPfx(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestExtractCertificatesFromPfx(t *testing.T) {
+	expected, err := LoadCertificateFile(filepath.Join("testdata", "test.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		password string
+		err      error
+	}{
+		{"PBES2 AES-256", "aes.pfx", testPfxPassword, nil},
+		{"PKCS#12 3DES", "3des.pfx", testPfxPassword, nil},
+		{"сертификат без шифрования", "plain.pfx", testPfxPassword, nil},
+		{"сертификат без шифрования, неверный пароль", "plain.pfx", "wrong", nil},
+		{"PBES2 AES-256, неверный пароль", "aes.pfx", "wrong", ErrPfxEncrypted},
+		{"PKCS#12 3DES, неверный пароль", "3des.pfx", "wrong", ErrPfxEncrypted},
+		{"PBES2 AES-256, без пароля", "aes.pfx", "", ErrPfxEncrypted},
+		{"неподдерживаемый RC2", "rc2.pfx", testPfxPassword, ErrPfxEncrypted},
+		{"без сертификата", "nocert.pfx", testPfxPassword, ErrPfxCertificateNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			certificates, err := ExtractCertificatesFromPfx(readTestPfx(t, test.file), test.password)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("ExtractCertificatesFromPfx(%s) error = %v, want %v", test.file, err, test.err)
+			}
+
+			if test.err != nil {
+				return
+			}
+
+			if len(certificates) != 1 || !certificates[0].Equal(expected) {
+				t.Errorf("ExtractCertificatesFromPfx(%s) returned unexpected certificates: %d", test.file, len(certificates))
+			}
+		})
+	}
+}
+
+func TestExtractCertificatesFromPfxBroken(t *testing.T) {
+	if _, err := ExtractCertificatesFromPfx([]byte("not a pfx"), testPfxPassword); err == nil {
+		t.Error("ExtractCertificatesFromPfx accepted broken data")
+	}
+}
+
+func TestResolvePfxPassword(t *testing.T) {
+	t.Setenv("CPMASS_TEST_PFX_PASSWORD", "env password")
+
+	pfxPassword := "plain:pfx"
+	containerPin := "pin"
+	envPassword := "env:CPMASS_TEST_PFX_PASSWORD"
+	missingEnv := "env:CPMASS_TEST_PFX_MISSING"
+	prompt := "prompt:Пароль"
+
+	tests := []struct {
+		name         string
+		pfxPassword  *string
+		containerPin *string
+		password     string
+	}{
+		{"пароль pfx", &pfxPassword, &containerPin, "pfx"},
+		{"PIN-код контейнера", nil, &containerPin, "pin"},
+		{"переменная окружения", &envPassword, nil, "env password"},
+		{"не задана переменная окружения", &missingEnv, nil, ""},
+		{"ввод с клавиатуры не запрашивается", &prompt, &containerPin, ""},
+		{"пароль не задан", nil, nil, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if password := ResolvePfxPassword(test.pfxPassword, test.containerPin); password != test.password {
+				t.Errorf("ResolvePfxPassword() = %q, want %q", password, test.password)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+	"golang.org/x/exp/slog"
+)
+
+// NamePreview - имя контейнера, которое получит сертификат при установке
+type NamePreview struct {
+	CertificatePath string
+	Name            ContainerName
+	Missing         []string
+	Changes         []string
+	Collisions      []string
+	Error           error
+}
+
+// findPreviewCertificates возвращает сертификаты пользователей из файла или папки, копии одного сертификата пропускаются
+func findPreviewCertificates(path string) ([]*discoveredCertificate, error) {
+	path, err := ResolveArchivePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	if info.IsDir() {
+		matcher, err := NewIgnoreMatcher(path, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var files signatureFiles
+		collectSignatureFiles(path, &files, matcher)
+		paths = files.certificates
+	} else {
+		paths = []string{path}
+	}
+
+	var certificates []*discoveredCertificate
+	seen := make(map[string]bool)
+	for _, group := range extractPublicKeyFromCertificates(paths, nil) {
+		for _, c := range group {
+			thumbprint := cades.GetThumbprint(c.Certificate)
+			if !seen[thumbprint] {
+				seen[thumbprint] = true
+				certificates = append(certificates, c)
+			}
+		}
+	}
+
+	sort.Slice(certificates, func(i, j int) bool {
+		return certificates[i].Path < certificates[j].Path
+	})
+	return certificates, nil
+}
+
+// PreviewNames подставляет данные каждого сертификата в шаблон без изменений в хранилище.
+// Совпадения ищутся среди полученных имен и среди установленных контейнеров.
+func PreviewNames(pattern string, path string) ([]*NamePreview, error) {
+	certificates, err := findPreviewCertificates(path)
+	if err != nil {
+		return nil, err
+	}
+
+	installedNames := make(map[string][]string)
+	if containers, err := GetListOfContainers(); err == nil {
+		for _, c := range containers {
+			name := strings.ToLower(containerShortName(&c))
+			installedNames[name] = append(installedNames[name], c.ContainerName)
+		}
+	} else {
+		slog.Debug(fmt.Sprintf("Cant get list of containers: %s", err))
+	}
+
+	var previews []*NamePreview
+	renderedNames := make(map[string][]*NamePreview)
+	for _, c := range certificates {
+		preview := &NamePreview{CertificatePath: c.Path}
+		previews = append(previews, preview)
+
+		nameCertificate, err := NewNameCertificate(c.Certificate)
+		if err != nil {
+			preview.Error = err
+			continue
+		}
+
+		rendered, err := RenderNamePattern(pattern, nameCertificate)
+		if err != nil {
+			preview.Error = err
+			continue
+		}
+
+		preview.Missing = rendered.Missing
+		preview.Name, preview.Changes = NewContainerName(rendered.Text)
+
+		name := strings.ToLower(preview.Name.Normal)
+		renderedNames[name] = append(renderedNames[name], preview)
+		preview.Collisions = append(preview.Collisions, installedNames[name]...)
+	}
+
+	for _, group := range renderedNames {
+		if len(group) < 2 {
+			continue
+		}
+
+		for _, preview := range group {
+			for _, other := range group {
+				if other != preview {
+					preview.Collisions = append(preview.Collisions, filepath.Base(other.CertificatePath))
+				}
+			}
+		}
+	}
+	return previews, nil
+}
+
+func PrintNamePreview(previews []*NamePreview) {
+	collisions := 0
+	for _, preview := range previews {
+		fmt.Println()
+		fmt.Println(preview.CertificatePath)
+		if preview.Error != nil {
+			fmt.Printf("  Ошибка: %s\n", preview.Error)
+			continue
+		}
+
+		fmt.Printf("  Имя: %s\n", preview.Name.Normal)
+		if len(preview.Missing) > 0 {
+			fmt.Printf("  Нет значения: %s\n", strings.Join(preview.Missing, ", "))
+		}
+		if len(preview.Changes) > 0 {
+			fmt.Printf("  Изменения: %s\n", strings.Join(preview.Changes, "; "))
+		}
+		if len(preview.Collisions) > 0 {
+			collisions++
+			fmt.Printf("  Совпадает с: %s\n", strings.Join(preview.Collisions, ", "))
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("Сертификатов: %d, совпадений имен: %d\n", len(previews), collisions)
+}
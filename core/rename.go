@@ -1,12 +1,31 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"unicode/utf8"
 
 	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
-	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/exp/slog"
+)
+
+// Политики при совпадении имени контейнера с уже установленным:
+//
+//	suffix     - добавить номер: "Иванов И.И. (2)" (по умолчанию)
+//	thumbprint - добавить начало отпечатка сертификата: "Иванов И.И. 1A2B3C4D"
+//	overwrite  - заменить существующий контейнер после успешной установки подписи
+//	skip       - не устанавливать подпись
+const (
+	NAME_COLLISION_SUFFIX     = "suffix"
+	NAME_COLLISION_THUMBPRINT = "thumbprint"
+	NAME_COLLISION_OVERWRITE  = "overwrite"
+	NAME_COLLISION_SKIP       = "skip"
+)
+
+var (
+	ErrContainerNameCollision = errors.New("container with the same name already exists")
+	ErrNameCollisionPolicy    = errors.New("unknown name collision policy")
 )
 
 type ContainerName struct {
@@ -14,94 +33,129 @@ type ContainerName struct {
 	Windows1251 string
 }
 
-func FormatNewName(pattern string, certificate *cades.GostCertificate) ContainerName {
-	result := ContainerName{}
-	names := []string{
-		"common_name",
-		"surname",
-		"country_name",
-		"locality_name",
-		"state_or_province_name",
-		"street_address",
-		"organization_name",
-		"organizational_unit_name",
-		"title",
-		"telephone_number",
-		"name",
-		"given_name",
-		"initials",
-		"pseudonym",
-		"email_address",
+// FormatNewName подставляет данные сертификата в шаблон и очищает имя,
+// при ошибке в шаблоне возвращается пустое имя
+func FormatNewName(pattern string, certificate *NameCertificate) ContainerName {
+	rendered, err := RenderNamePattern(pattern, certificate)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Ошибка в шаблоне имени[%s]: %s", pattern, err))
+		return ContainerName{}
 	}
 
-	symbols := map[string]string{
-		"expire_after":  "",
-		"expire_before": "",
+	if len(rendered.Missing) > 0 {
+		slog.Debug(fmt.Sprintf("Name pattern fields without value: %s", strings.Join(rendered.Missing, ", ")))
 	}
-	for _, name := range names {
-		symbols[fmt.Sprintf("subject.%s", name)] = name
-		symbols[fmt.Sprintf("issuer.%s", name)] = name
+
+	result, changes := NewContainerName(rendered.Text)
+	if len(changes) > 0 {
+		slog.Info(fmt.Sprintf("Имя контейнера[%s] изменено на [%s]: %s", rendered.Text, result.Normal, strings.Join(changes, ", ")))
 	}
+	return result
+}
 
-	for key, value := range symbols {
-		if !strings.Contains(pattern, "#"+key) {
+// ParseNameCollisionPolicy проверяет политику совпадения имени, регистр букв не учитывается,
+// пустое значение означает suffix
+func ParseNameCollisionPolicy(policy string) (string, error) {
+	switch value := strings.ToLower(strings.TrimSpace(policy)); value {
+	case "":
+		return NAME_COLLISION_SUFFIX, nil
+	case NAME_COLLISION_SUFFIX, NAME_COLLISION_THUMBPRINT, NAME_COLLISION_OVERWRITE, NAME_COLLISION_SKIP:
+		return value, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrNameCollisionPolicy, policy)
+	}
+}
+
+// withSuffix добавляет суффикс к имени, сокращая имя так, чтобы результат не превышал MAX_CONTAINER_NAME_LENGTH.
+// Имя в Windows-1251 однобайтовое, поэтому обе формы сокращаются на одинаковое число символов.
+func (name ContainerName) withSuffix(suffix string) ContainerName {
+	normal := []rune(name.Normal)
+	windows1251 := name.Windows1251
+	if limit := MAX_CONTAINER_NAME_LENGTH - utf8.RuneCountInString(suffix); len(normal) > limit && len(windows1251) == len(normal) {
+		normal = normal[:limit]
+		windows1251 = windows1251[:limit]
+	}
+
+	return ContainerName{
+		Normal:      strings.TrimRight(string(normal), " ") + suffix,
+		Windows1251: strings.TrimRight(windows1251, " ") + suffix,
+	}
+}
+
+// findContainersByName возвращает установленные контейнеры считывателя с тем же именем, кроме самого контейнера
+func findContainersByName(containers []cades.Container, container *cades.Container, name string) []cades.Container {
+	var result []cades.Container
+	reader := ContainerReader(container)
+	for _, c := range containers {
+		if c.UniqueContainerName == container.UniqueContainerName || ContainerReader(&c) != reader {
 			continue
 		}
 
-		switch strings.Split(key, ".")[0] {
-		case "subject":
-			if v, ok := certificate.Subject[value]; ok {
-				value = v
-			} else {
-				if value == "initials" {
-					if v, ok = certificate.Subject["given_name"]; ok {
-						value = ""
-						for _, i := range strings.Split(v, " ") {
-							r, _ := utf8.DecodeRuneInString(i)
-							value += string(r) + "."
-						}
-					} else {
-						value = "None"
-					}
-				} else {
-					value = "None"
-				}
-			}
-
-		case "issuer":
-			if v, ok := certificate.Issuer[value]; ok {
-				value = v
-			} else {
-				if value == "initials" {
-					if v, ok = certificate.Issuer["given_name"]; ok {
-						value = ""
-						for _, i := range strings.Split(v, " ") {
-							r, _ := utf8.DecodeRuneInString(i)
-							value += string(r) + "."
-						}
-					} else {
-						value = "None"
-					}
-				} else {
-					value = "None"
-				}
-			}
-		case "expire_after":
-			value = certificate.NotAfter.Format("02.01.2006")
-		case "expire_before":
-			value = certificate.NotBefore.Format("02.01.2006")
-		default:
+		if strings.EqualFold(containerShortName(&c), name) {
+			result = append(result, c)
 		}
-		pattern = strings.ReplaceAll(pattern, "#"+key, value)
 	}
-	result.Normal = pattern
+	return result
+}
+
+// ResolveNameCollision проверяет, что имя не занято другим контейнером того же считывателя,
+// и при совпадении выбирает имя по политике. При политике overwrite возвращается временное имя
+// и контейнеры, которые удаляются через ReplaceContainers только после установки подписи.
+func ResolveNameCollision(container *cades.Container, name ContainerName, thumbprint string, policy string) (ContainerName, []cades.Container, error) {
+	policy, err := ParseNameCollisionPolicy(policy)
+	if err != nil {
+		return name, nil, err
+	}
 
-	encoder := charmap.Windows1251.NewEncoder()
-	cp1251String, err := encoder.String(pattern)
+	containers, err := GetListOfContainers()
 	if err != nil {
-		return ContainerName{}
+		slog.Debug(fmt.Sprintf("Cant check container name collision: %s", err))
+		return name, nil, nil
 	}
-	result.Windows1251 = cp1251String
 
-	return result
+	collisions := findContainersByName(containers, container, name.Normal)
+	if len(collisions) == 0 {
+		return name, nil, nil
+	}
+
+	result := name
+	switch policy {
+	case NAME_COLLISION_SKIP:
+		return name, nil, ErrContainerNameCollision
+	case NAME_COLLISION_THUMBPRINT:
+		if len(thumbprint) > 8 {
+			thumbprint = thumbprint[:8]
+		}
+		result = name.withSuffix(" " + strings.ToUpper(thumbprint))
+	}
+
+	// Номер добавляется и при совпадении имени с фрагментом отпечатка
+	base := result
+	for i := 2; len(findContainersByName(containers, container, result.Normal)) > 0; i++ {
+		result = base.withSuffix(fmt.Sprintf(" (%d)", i))
+	}
+
+	if policy == NAME_COLLISION_OVERWRITE {
+		slog.Warn(fmt.Sprintf("Контейнер с именем[%s] уже существует и будет заменен после установки подписи", name.Normal))
+		return result, collisions, nil
+	}
+
+	slog.Warn(fmt.Sprintf(
+		"Контейнер с именем[%s] уже существует, использовано имя[%s] (политика: %s)",
+		name.Normal, result.Normal, policy,
+	))
+	return result, nil, nil
+}
+
+// ReplaceContainers удаляет контейнеры с совпадающим именем и переименовывает установленный контейнер
+// в освободившееся имя. Если удалить или переименовать не удалось, контейнер остается под временным именем.
+func ReplaceContainers(container *cades.Container, replaced []cades.Container, name ContainerName, pin string) (*cades.Container, error) {
+	for _, c := range replaced {
+		if !DeleteContainer(&c) {
+			return container, fmt.Errorf("не удалось удалить контейнер[%s]", c.ContainerName)
+		}
+		slog.Info(fmt.Sprintf("Контейнер[%s] удален по политике overwrite", c.ContainerName))
+	}
+
+	return RenameContainer(container, name, pin)
 }
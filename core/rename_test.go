@@ -0,0 +1,60 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseNameCollisionPolicy(t *testing.T) {
+	tests := []struct {
+		input  string
+		result string
+		err    error
+	}{
+		{"", NAME_COLLISION_SUFFIX, nil},
+		{"suffix", NAME_COLLISION_SUFFIX, nil},
+		{" Thumbprint ", NAME_COLLISION_THUMBPRINT, nil},
+		{"OVERWRITE", NAME_COLLISION_OVERWRITE, nil},
+		{"skip", NAME_COLLISION_SKIP, nil},
+		{"overwirte", "", ErrNameCollisionPolicy},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			result, err := ParseNameCollisionPolicy(test.input)
+			if result != test.result || !errors.Is(err, test.err) {
+				t.Errorf("ParseNameCollisionPolicy(%q) = %q, %v, want %q, %v", test.input, result, err, test.result, test.err)
+			}
+		})
+	}
+}
+
+func TestContainerNameWithSuffix(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		suffix string
+		result string
+	}{
+		{"короткое имя", "Иванов И.И.", " (2)", "Иванов И.И. (2)"},
+		{"имя на пределе", strings.Repeat("я", MAX_CONTAINER_NAME_LENGTH), " (2)", strings.Repeat("я", MAX_CONTAINER_NAME_LENGTH-4) + " (2)"},
+		{"пробел на границе", strings.Repeat("я", MAX_CONTAINER_NAME_LENGTH-10) + " " + strings.Repeat("я", 9), " 1A2B3C4D", strings.Repeat("я", MAX_CONTAINER_NAME_LENGTH-10) + " 1A2B3C4D"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, _ := NewContainerName(test.input)
+			result := name.withSuffix(test.suffix)
+
+			if result.Normal != test.result {
+				t.Errorf("withSuffix(%q) = %q, want %q", test.suffix, result.Normal, test.result)
+			}
+
+			if utf8.RuneCountInString(result.Normal) > MAX_CONTAINER_NAME_LENGTH || len(result.Windows1251) != utf8.RuneCountInString(result.Normal) {
+				t.Errorf("withSuffix(%q) length = %d/%d", test.suffix, utf8.RuneCountInString(result.Normal), len(result.Windows1251))
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// RevokedCertificate - сертификат пользователя, найденный в локальном списке отзыва
+type RevokedCertificate struct {
+	CertificatePath string
+	Owner           string
+	SerialNumber    string
+	RevocationTime  time.Time
+	CRLPath         string
+	Skipped         bool
+}
+
+var (
+	revocationCertsFolder string
+	revocationCRLs        []*CRLFile
+	revocationCRLsLoaded  bool
+	revokedCertificates   []*RevokedCertificate
+)
+
+// SetRevocationCheck включает проверку отзыва сертификатов по спискам отзыва из папок root и crl
+func SetRevocationCheck(certsFolderPath string) {
+	revocationCertsFolder = certsFolderPath
+	revocationCRLsLoaded = false
+	revokedCertificates = nil
+}
+
+// isCRLIssuer сравнивает издателя сертификата и списка отзыва, подпись списка не проверяется
+func isCRLIssuer(certificate *x509.Certificate, crl *x509.RevocationList) bool {
+	if !bytes.Equal(certificate.RawIssuer, crl.RawIssuer) {
+		return false
+	}
+
+	if len(certificate.AuthorityKeyId) > 0 && len(crl.AuthorityKeyId) > 0 {
+		return bytes.Equal(certificate.AuthorityKeyId, crl.AuthorityKeyId)
+	}
+	return true
+}
+
+// FindRevocation ищет сертификат в списках отзыва его издателя. Второе значение - найден ли
+// хотя бы один список отзыва издателя.
+func FindRevocation(certificate *x509.Certificate, crls []*CRLFile) (*RevokedCertificate, bool) {
+	matched := false
+	for _, file := range crls {
+		if !isCRLIssuer(certificate, file.CRL) {
+			continue
+		}
+		matched = true
+
+		for _, revoked := range file.CRL.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(certificate.SerialNumber) == 0 {
+				return &RevokedCertificate{
+					SerialNumber:   fmt.Sprintf("%X", certificate.SerialNumber),
+					RevocationTime: revoked.RevocationTime,
+					CRLPath:        file.Path,
+				}, true
+			}
+		}
+	}
+	return nil, matched
+}
+
+// CheckCertificateRevocation проверяет сертификат по локальным спискам отзыва без обращения к сети.
+// Отозванный сертификат попадает в отчет, действие задается проверкой revoked в блоке checks.
+func CheckCertificateRevocation(certificate *x509.Certificate, certificateFilename string) error {
+	if revocationCertsFolder == "" {
+		return nil
+	}
+
+	if !revocationCRLsLoaded {
+		revocationCRLs = FindCRLFiles(revocationCertsFolder)
+		revocationCRLsLoaded = true
+	}
+
+	revoked, matched := FindRevocation(certificate, revocationCRLs)
+	if !matched {
+		slog.Debug(fmt.Sprintf("CRL for certificate[%s] issuer %s not found", certificateFilename, certificate.Issuer.CommonName))
+		return nil
+	}
+	if revoked == nil {
+		return nil
+	}
+
+	check := certificateCheck{certificateChecks.Revoked, fmt.Sprintf(
+		"сертификат отозван %s (список отзыва %s)",
+		revoked.RevocationTime.Format("02.01.2006 15:04:05"), filepath.Base(revoked.CRLPath),
+	)}
+	err := applyCheckAction(check, certificateFilename)
+
+	revoked.CertificatePath = certificateFilename
+	revoked.Owner = certificate.Subject.CommonName
+	revoked.Skipped = err != nil
+	revokedCertificates = append(revokedCertificates, revoked)
+	return err
+}
+
+// PrintRevocationReport выводит список отозванных сертификатов
+func PrintRevocationReport() {
+	if len(revokedCertificates) == 0 {
+		return
+	}
+
+	var lines []string
+	for _, revoked := range revokedCertificates {
+		status := "установлен"
+		if revoked.Skipped {
+			status = "не установлен"
+		}
+
+		lines = append(lines, fmt.Sprintf(
+			"  Сертификат[%s] %s (Владелец: %s, серийный номер %s, отозван %s)",
+			revoked.CertificatePath, status, revoked.Owner, revoked.SerialNumber, revoked.RevocationTime.Format("02.01.2006"),
+		))
+	}
+
+	fmt.Println()
+	slog.Warn(fmt.Sprintf("Отозванные сертификаты (%d):\n%s", len(revokedCertificates), strings.Join(lines, "\n")))
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+	"golang.org/x/exp/slog"
+)
+
+// Хранилища сертификатов текущего пользователя
+const (
+	CERTIFICATE_STORE_ROOT = "uRoot"
+	CERTIFICATE_STORE_CA   = "uCA"
+)
+
+// RootPreview - сертификат из папки root и хранилище, в которое он будет установлен
+type RootPreview struct {
+	Path        string
+	Certificate *x509.Certificate
+	Store       string
+	Installed   bool
+	Error       error
+}
+
+// CertificateStore возвращает хранилище для сертификата УЦ: самоподписанный - корневые,
+// остальные - промежуточные
+func CertificateStore(certificate *x509.Certificate) string {
+	if isSelfSigned(certificate) {
+		return CERTIFICATE_STORE_ROOT
+	}
+	return CERTIFICATE_STORE_CA
+}
+
+func certificateStoreName(store string) string {
+	if store == CERTIFICATE_STORE_ROOT {
+		return "корневые"
+	}
+	return "промежуточные"
+}
+
+// loadRootFile возвращает сертификаты из файла .cer или p7b цепочки
+func loadRootFile(path string) ([]*x509.Certificate, error) {
+	if IsPkcs7File(path) {
+		return LoadPkcs7File(path)
+	}
+
+	certificate, err := LoadCertificateFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return []*x509.Certificate{certificate}, nil
+}
+
+// installCertificateToStore устанавливает сертификат, для p7b цепочки сертификат сохраняется в отдельный файл
+func installCertificateToStore(path string, certificate *x509.Certificate, store string) error {
+	var certificatePath string
+	var err error
+	if IsPkcs7File(path) {
+		certificatePath, err = SaveTempCertificate(certificate, filepath.Base(path))
+	} else {
+		certificatePath, err = NormalizeCertificateFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	m := cades.CadesManager{}
+	return m.InstallCertificate(certificatePath, store, false)
+}
+
+// PreviewRootFolder определяет хранилище для каждого сертификата из папки root без изменений в хранилище
+func PreviewRootFolder(rootFolder string) ([]*RootPreview, error) {
+	folderEntity, err := os.ReadDir(rootFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	var previews []*RootPreview
+	for _, entity := range folderEntity {
+		if entity.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(rootFolder, entity.Name())
+		certificates, err := loadRootFile(path)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("File[%s] is not a certificate, error: %s", path, err.Error()))
+			continue
+		}
+
+		integrityErr := CheckManifestIntegrity(path)
+		for _, c := range certificates {
+			preview := &RootPreview{Path: path, Certificate: c, Store: CertificateStore(c), Error: integrityErr}
+			preview.Installed, _ = IsCertificateExists(cades.GetThumbprint(c), preview.Store)
+			previews = append(previews, preview)
+		}
+	}
+	return previews, nil
+}
+
+func PrintRootPreview(previews []*RootPreview) {
+	counts := make(map[string]int)
+	for _, preview := range previews {
+		fmt.Println()
+		fmt.Printf("%s: %s\n", filepath.Base(preview.Path), preview.Certificate.Subject.CommonName)
+		if preview.Error != nil {
+			fmt.Printf("  Пропущен: %s\n", preview.Error)
+			continue
+		}
+
+		status := ""
+		if preview.Installed {
+			status = ", уже установлен"
+		}
+		fmt.Printf("  Хранилище: %s (%s)%s\n", preview.Store, certificateStoreName(preview.Store), status)
+		fmt.Printf("  Действителен до: %s\n", preview.Certificate.NotAfter.Format("02.01.2006 15:04:05"))
+		counts[preview.Store]++
+	}
+
+	fmt.Println()
+	var summary []string
+	for _, store := range []string{CERTIFICATE_STORE_ROOT, CERTIFICATE_STORE_CA} {
+		summary = append(summary, fmt.Sprintf("%s: %d", store, counts[store]))
+	}
+	fmt.Printf("Сертификатов: %d, %s\n", len(previews), strings.Join(summary, ", "))
+}
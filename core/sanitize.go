@@ -0,0 +1,104 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Имя контейнера записывается в name.key в Windows-1251, длина ограничена как в cades.NewPrivateKeyName
+const MAX_CONTAINER_NAME_LENGTH = 125
+
+// Символы, которые считыватели не принимают в имени контейнера: разделитель пути и запрещенные в именях файлов.
+// Кавычки допустимы и остаются в названиях организаций: ООО "Ромашка"
+const forbiddenContainerNameChars = `\/:*?<>|`
+
+// Замены для символов без представления в Windows-1251. Типографские кавычки, тире и многоточие
+// есть в Windows-1251 и сохраняются как есть
+var containerNameReplacements = map[rune]string{
+	'‐': "-", '‑': "-", '‒': "-", '―': "-", '−': "-", '‛': "'",
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'ø': "o", 'Ø': "O", 'ł': "l", 'Ł': "L", 'đ': "d", 'Đ': "D",
+}
+
+func isCp1251Encodable(r rune) bool {
+	_, ok := charmap.Windows1251.EncodeRune(r)
+	return ok
+}
+
+// transliterateRune заменяет символ, отсутствующий в Windows-1251: диакритика снимается (é -> e),
+// для остальных используется таблица замен, символы без замены (emoji) удаляются
+func transliterateRune(r rune) string {
+	if replacement, ok := containerNameReplacements[r]; ok {
+		return replacement
+	}
+
+	stripped, _, err := transform.String(
+		transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC),
+		string(r),
+	)
+	if err == nil && stripped != "" {
+		for _, c := range stripped {
+			if !isCp1251Encodable(c) {
+				return ""
+			}
+		}
+		return stripped
+	}
+	return ""
+}
+
+// SanitizeContainerName приводит имя к виду, допустимому для считывателей, и возвращает список изменений
+func SanitizeContainerName(name string) (string, []string) {
+	var changes []string
+	var forbidden, transliterated, removed []string
+
+	var builder strings.Builder
+	for _, r := range name {
+		switch {
+		case strings.ContainsRune(forbiddenContainerNameChars, r) || unicode.IsControl(r):
+			forbidden = append(forbidden, fmt.Sprintf("%q", r))
+			builder.WriteRune('_')
+		case isCp1251Encodable(r):
+			builder.WriteRune(r)
+		default:
+			replacement := transliterateRune(r)
+			if replacement == "" {
+				removed = append(removed, string(r))
+			} else {
+				transliterated = append(transliterated, fmt.Sprintf("%c -> %s", r, replacement))
+			}
+			builder.WriteString(replacement)
+		}
+	}
+
+	if len(forbidden) > 0 {
+		changes = append(changes, fmt.Sprintf("запрещенные символы заменены на _: %s", strings.Join(forbidden, " ")))
+	}
+	if len(transliterated) > 0 {
+		changes = append(changes, fmt.Sprintf("транслитерация: %s", strings.Join(transliterated, ", ")))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, fmt.Sprintf("удалены символы без представления в Windows-1251: %s", strings.Join(removed, " ")))
+	}
+
+	result := strings.Join(strings.Fields(builder.String()), " ")
+	if nameRunes := []rune(result); len(nameRunes) > MAX_CONTAINER_NAME_LENGTH {
+		result = strings.TrimSpace(string(nameRunes[:MAX_CONTAINER_NAME_LENGTH]))
+		changes = append(changes, fmt.Sprintf("имя сокращено до %d символов", MAX_CONTAINER_NAME_LENGTH))
+	}
+	return result, changes
+}
+
+// NewContainerName очищает имя и кодирует его в Windows-1251
+func NewContainerName(name string) (ContainerName, []string) {
+	sanitized, changes := SanitizeContainerName(name)
+
+	// После очистки все символы кодируются в Windows-1251
+	cp1251String, _ := charmap.Windows1251.NewEncoder().String(sanitized)
+	return ContainerName{Normal: sanitized, Windows1251: cp1251String}, changes
+}
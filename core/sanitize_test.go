@@ -0,0 +1,60 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestContainerNameReplacements(t *testing.T) {
+	for r := range containerNameReplacements {
+		if isCp1251Encodable(r) {
+			t.Errorf("replacement for %q is never applied: the character is encodable in Windows-1251", r)
+		}
+	}
+}
+
+func TestSanitizeContainerName(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		result  string
+		changed bool
+	}{
+		{"без изменений", "Иванов И.И. - Директор", "Иванов И.И. - Директор", false},
+		{"кавычки", `ООО "Ромашка"`, `ООО "Ромашка"`, false},
+		{"кавычки-елочки", "ООО «Ромашка»", "ООО «Ромашка»", false},
+		{"типографские символы", "„Ромашка“ – ‘Лютик’…", "„Ромашка“ – ‘Лютик’…", false},
+		{"запрещенные символы", `a\b/c:d*e?f<g>h|i`, "a_b_c_d_e_f_g_h_i", true},
+		{"управляющие символы", "a\tb", "a_b", true},
+		{"диакритика", "Café Müller", "Cafe Muller", true},
+		{"таблица замен", "a‒b ß", "a-b ss", true},
+		{"emoji", "Иванов 😀 И.И.", "Иванов И.И.", true},
+		{"пробелы", "  Иванов   И.И.  ", "Иванов И.И.", false},
+		{"длинное имя", strings.Repeat("я", MAX_CONTAINER_NAME_LENGTH+10), strings.Repeat("я", MAX_CONTAINER_NAME_LENGTH), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, changes := SanitizeContainerName(test.input)
+			if result != test.result {
+				t.Errorf("SanitizeContainerName(%q) = %q, want %q", test.input, result, test.result)
+			}
+
+			if changed := len(changes) > 0; changed != test.changed {
+				t.Errorf("SanitizeContainerName(%q) changes = %v, want changed %t", test.input, changes, test.changed)
+			}
+		})
+	}
+}
+
+func TestNewContainerName(t *testing.T) {
+	name, _ := NewContainerName(`ООО "Ромашка" Café`)
+	if name.Normal != `ООО "Ромашка" Cafe` {
+		t.Errorf("NewContainerName() = %q", name.Normal)
+	}
+
+	if len(name.Windows1251) != utf8.RuneCountInString(name.Normal) {
+		t.Errorf("NewContainerName() Windows1251 length = %d, want %d", len(name.Windows1251), utf8.RuneCountInString(name.Normal))
+	}
+}
@@ -0,0 +1,422 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/exp/slog"
+	"golang.org/x/term"
+)
+
+// Ссылки на секреты в полях паролей:
+//
+//	env:NAME      - переменная окружения NAME
+//	file:path     - содержимое файла (завершающий перевод строки отбрасывается)
+//	prompt        - ввод с клавиатуры без отображения символов
+//	prompt:Текст  - то же, с собственным текстом приглашения
+//	secret:NAME   - запись NAME из защищенного паролем файла секретов
+//	plain:value   - значение как есть (если пароль начинается с одного из префиксов)
+//
+// Значения без известного префикса используются как есть.
+const (
+	SECRET_ENV_PREFIX    = "env:"
+	SECRET_FILE_PREFIX   = "file:"
+	SECRET_PROMPT_PREFIX = "prompt"
+	SECRET_STORE_PREFIX  = "secret:"
+	SECRET_PLAIN_PREFIX  = "plain:"
+
+	// Более короткие секреты скрываются только как значение атрибута целиком,
+	// замена подстрок для них искажает логи
+	MIN_REDACT_SECRET_LENGTH = 4
+
+	SECRETS_FILENAME             = "secrets.enc"
+	SECRETS_PASSWORD_ENVIRONMENT = "CPMASS_SECRETS_PASSWORD"
+)
+
+var (
+	ErrSecretNotFound       = errors.New("secret not found")
+	ErrSecretsFileNotFound  = errors.New("secrets file not found")
+	ErrSecretsWrongPassword = errors.New("wrong secrets file password")
+)
+
+var (
+	secretValues      []string
+	secretValuesMutex sync.RWMutex
+	cachedSecrets     map[string]string
+	cachedSecretsPath string
+
+	stdinReader = bufio.NewReader(os.Stdin)
+)
+
+type secretsFile struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// ResolveSecret возвращает значение секрета по ссылке, label используется в приглашении ввода
+func ResolveSecret(reference string, label string) (string, error) {
+	var value string
+
+	switch {
+	case strings.HasPrefix(reference, SECRET_PLAIN_PREFIX):
+		value = strings.TrimPrefix(reference, SECRET_PLAIN_PREFIX)
+
+	case strings.HasPrefix(reference, SECRET_ENV_PREFIX):
+		name := strings.TrimPrefix(reference, SECRET_ENV_PREFIX)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("переменная окружения %s не задана: %w", name, ErrSecretNotFound)
+		}
+		value = v
+
+	case strings.HasPrefix(reference, SECRET_FILE_PREFIX):
+		path := strings.TrimPrefix(reference, SECRET_FILE_PREFIX)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("не удалось прочитать файл с паролем[%s]: %w", path, err)
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+
+	case IsPromptSecret(reference):
+		text := strings.TrimPrefix(strings.TrimPrefix(reference, SECRET_PROMPT_PREFIX), ":")
+		if text == "" {
+			text = fmt.Sprintf("Введите пароль для %s", label)
+		}
+		v, err := ReadPassword(text)
+		if err != nil {
+			return "", err
+		}
+		value = v
+
+	case strings.HasPrefix(reference, SECRET_STORE_PREFIX):
+		name := strings.TrimPrefix(reference, SECRET_STORE_PREFIX)
+		secrets, err := loadCachedSecrets()
+		if err != nil {
+			return "", err
+		}
+		v, ok := secrets[name]
+		if !ok {
+			return "", fmt.Errorf("запись %s отсутствует в файле секретов: %w", name, ErrSecretNotFound)
+		}
+		value = v
+
+	default:
+		value = reference
+	}
+
+	RegisterSecretValue(value)
+	return value, nil
+}
+
+// IsPromptSecret проверяет, что ссылка требует ввода секрета с клавиатуры
+func IsPromptSecret(reference string) bool {
+	return reference == SECRET_PROMPT_PREFIX || strings.HasPrefix(reference, SECRET_PROMPT_PREFIX+":")
+}
+
+// ReadPassword запрашивает пароль без отображения вводимых символов
+func ReadPassword(prompt string) (string, error) {
+	fmt.Printf("%s: ", prompt)
+	defer fmt.Println()
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readPasswordLine(stdinReader)
+	}
+
+	data, err := term.ReadPassword(fd)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// readPasswordLine читает строку целиком, включая пробелы, без завершающего перевода строки
+func readPasswordLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// RegisterSecretValue добавляет значение в список скрываемых в логах
+func RegisterSecretValue(value string) {
+	if value == "" {
+		return
+	}
+
+	secretValuesMutex.Lock()
+	defer secretValuesMutex.Unlock()
+	for _, v := range secretValues {
+		if v == value {
+			return
+		}
+	}
+	secretValues = append(secretValues, value)
+	// Сначала заменяются более длинные значения, чтобы не оставлять их части
+	sort.Slice(secretValues, func(i, j int) bool {
+		return len(secretValues[i]) > len(secretValues[j])
+	})
+}
+
+// RedactSecrets заменяет в тексте зарегистрированные секреты длиной от MIN_REDACT_SECRET_LENGTH символов
+func RedactSecrets(text string) string {
+	secretValuesMutex.RLock()
+	defer secretValuesMutex.RUnlock()
+	for _, v := range secretValues {
+		if len([]rune(v)) < MIN_REDACT_SECRET_LENGTH {
+			continue
+		}
+		text = strings.ReplaceAll(text, v, "******")
+	}
+	return text
+}
+
+// redactValue скрывает значение атрибута целиком, если оно совпадает с секретом, иначе заменяет секреты в тексте
+func redactValue(text string) string {
+	secretValuesMutex.RLock()
+	for _, v := range secretValues {
+		if text == v {
+			secretValuesMutex.RUnlock()
+			return "******"
+		}
+	}
+	secretValuesMutex.RUnlock()
+	return RedactSecrets(text)
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(redactValue(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		attrs := make([]slog.Attr, 0, len(group))
+		for _, attr := range group {
+			attrs = append(attrs, redactAttr(attr))
+		}
+		a.Value = slog.GroupValue(attrs...)
+	}
+	return a
+}
+
+func redactAttrs(attrs []slog.Attr) []slog.Attr {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		redacted = append(redacted, redactAttr(a))
+	}
+	return redacted
+}
+
+type redactHandler struct {
+	handler slog.Handler
+}
+
+// NewRedactHandler скрывает зарегистрированные секреты в сообщениях и атрибутах логов
+func NewRedactHandler(handler slog.Handler) slog.Handler {
+	return &redactHandler{handler: handler}
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, record slog.Record) error {
+	newRecord := slog.NewRecord(record.Time, record.Level, RedactSecrets(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		newRecord.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.handler.Handle(ctx, newRecord)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &redactHandler{handler: h.handler.WithAttrs(redactAttrs(attrs))}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{handler: h.handler.WithGroup(name)}
+}
+
+func GetSecretsFilePath() string {
+	if cachedSecretsPath != "" {
+		return cachedSecretsPath
+	}
+	return SECRETS_FILENAME
+}
+
+func SetSecretsFilePath(path string) {
+	cachedSecretsPath = path
+	cachedSecrets = nil
+}
+
+func getSecretsPassword() (string, error) {
+	if v, ok := os.LookupEnv(SECRETS_PASSWORD_ENVIRONMENT); ok {
+		RegisterSecretValue(v)
+		return v, nil
+	}
+
+	password, err := ReadPassword("Введите пароль от файла секретов")
+	if err != nil {
+		return "", err
+	}
+	RegisterSecretValue(password)
+	return password, nil
+}
+
+func loadCachedSecrets() (map[string]string, error) {
+	if cachedSecrets != nil {
+		return cachedSecrets, nil
+	}
+
+	password, err := getSecretsPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := LoadSecrets(GetSecretsFilePath(), password)
+	if err != nil {
+		return nil, err
+	}
+	cachedSecrets = secrets
+	return secrets, nil
+}
+
+func deriveSecretsKey(password string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(password), salt, 1<<15, 8, 1, 32)
+}
+
+func LoadSecrets(path string, password string) (map[string]string, error) {
+	secrets := make(map[string]string)
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, fmt.Errorf("%s: %w", path, ErrSecretsFileNotFound)
+	} else if err != nil {
+		return secrets, err
+	}
+
+	var file secretsFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return secrets, err
+	}
+
+	key, err := deriveSecretsKey(password, file.Salt)
+	if err != nil {
+		return secrets, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return secrets, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return secrets, err
+	}
+
+	data, err := gcm.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return secrets, ErrSecretsWrongPassword
+	}
+
+	err = json.Unmarshal(data, &secrets)
+	for _, v := range secrets {
+		RegisterSecretValue(v)
+	}
+	return secrets, err
+}
+
+func SaveSecrets(path string, password string, secrets map[string]string) error {
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	file := secretsFile{
+		Salt:  make([]byte, 16),
+		Nonce: make([]byte, 12),
+	}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+
+	key, err := deriveSecretsKey(password, file.Salt)
+	if err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	file.Data = gcm.Seal(nil, file.Nonce, data, nil)
+
+	raw, err := json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0600)
+}
+
+// SetSecret добавляет или обновляет запись в файле секретов, пустое значение удаляет запись
+func SetSecret(name string, value string) error {
+	password, err := getSecretsPassword()
+	if err != nil {
+		return err
+	}
+
+	path := GetSecretsFilePath()
+	secrets, err := LoadSecrets(path, password)
+	if err != nil && !errors.Is(err, ErrSecretsFileNotFound) {
+		return err
+	}
+
+	if value == "" {
+		delete(secrets, name)
+	} else {
+		secrets[name] = value
+	}
+
+	err = SaveSecrets(path, password, secrets)
+	if err == nil {
+		cachedSecrets = secrets
+	}
+	return err
+}
+
+func ListSecrets() ([]string, error) {
+	secrets, err := loadCachedSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slog"
+)
+
+// resetSecretValues очищает список скрываемых значений на время теста
+func resetSecretValues(t *testing.T) {
+	t.Helper()
+
+	secretValuesMutex.Lock()
+	saved := secretValues
+	secretValues = nil
+	secretValuesMutex.Unlock()
+
+	t.Cleanup(func() {
+		secretValuesMutex.Lock()
+		secretValues = saved
+		secretValuesMutex.Unlock()
+	})
+}
+
+func TestResolveSecret(t *testing.T) {
+	resetSecretValues(t)
+
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password.txt")
+	writeTestFile(t, passwordFile, "file password\r\n")
+
+	secretsFile := filepath.Join(dir, SECRETS_FILENAME)
+	if err := SaveSecrets(secretsFile, "master", map[string]string{"pfx": "store password"}); err != nil {
+		t.Fatal(err)
+	}
+	SetSecretsFilePath(secretsFile)
+	t.Cleanup(func() { SetSecretsFilePath("") })
+
+	t.Setenv(SECRETS_PASSWORD_ENVIRONMENT, "master")
+	t.Setenv("CPMASS_TEST_SECRET", "env password")
+
+	tests := []struct {
+		name      string
+		reference string
+		value     string
+		err       error
+	}{
+		{"переменная окружения", "env:CPMASS_TEST_SECRET", "env password", nil},
+		{"не задана переменная окружения", "env:CPMASS_TEST_MISSING", "", ErrSecretNotFound},
+		{"файл", "file:" + passwordFile, "file password", nil},
+		{"нет файла", "file:" + filepath.Join(dir, "missing.txt"), "", os.ErrNotExist},
+		{"значение как есть", "plain:env:value", "env:value", nil},
+		{"без префикса", "password", "password", nil},
+		{"файл секретов", "secret:pfx", "store password", nil},
+		{"нет записи в файле секретов", "secret:missing", "", ErrSecretNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := ResolveSecret(test.reference, "test")
+			if !errors.Is(err, test.err) {
+				t.Fatalf("ResolveSecret(%q) error = %v, want %v", test.reference, err, test.err)
+			}
+
+			if value != test.value {
+				t.Errorf("ResolveSecret(%q) = %q, want %q", test.reference, value, test.value)
+			}
+		})
+	}
+}
+
+func TestSaveLoadSecrets(t *testing.T) {
+	resetSecretValues(t)
+
+	path := filepath.Join(t.TempDir(), SECRETS_FILENAME)
+	secrets := map[string]string{"pin": "12345678", "pfx": "pa ss"}
+
+	if err := SaveSecrets(path, "master", secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadSecrets(path, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) != len(secrets) || loaded["pin"] != secrets["pin"] || loaded["pfx"] != secrets["pfx"] {
+		t.Errorf("LoadSecrets() = %v, want %v", loaded, secrets)
+	}
+
+	if _, err := LoadSecrets(path, "wrong"); !errors.Is(err, ErrSecretsWrongPassword) {
+		t.Errorf("LoadSecrets() with wrong password error = %v, want ErrSecretsWrongPassword", err)
+	}
+
+	if _, err := LoadSecrets(filepath.Join(t.TempDir(), "missing.enc"), "master"); !errors.Is(err, ErrSecretsFileNotFound) {
+		t.Errorf("LoadSecrets() for missing file error = %v, want ErrSecretsFileNotFound", err)
+	}
+}
+
+func TestRedactHandler(t *testing.T) {
+	resetSecretValues(t)
+	RegisterSecretValue("longsecret")
+	RegisterSecretValue("ab")
+
+	var buffer bytes.Buffer
+	logger := slog.New(NewRedactHandler(slog.NewTextHandler(&buffer, nil)))
+
+	logger.With("token", "longsecret").Info(
+		"about longsecret",
+		slog.String("pin", "ab"),
+		slog.String("path", "C:/about/tab"),
+		slog.Group("auth", slog.String("password", "x longsecret"), slog.Group("container", slog.String("pin", "ab"))),
+	)
+
+	output := buffer.String()
+	if strings.Contains(output, "longsecret") {
+		t.Errorf("secret is not redacted: %s", output)
+	}
+
+	for _, expected := range []string{
+		`msg="about ******"`,
+		"token=******",
+		"pin=******",
+		"path=C:/about/tab",
+		`auth.password="x ******"`,
+		"auth.container.pin=******",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("log %q does not contain %q", output, expected)
+		}
+	}
+}
+
+func TestReadPasswordLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		value string
+	}{
+		{"пароль с пробелами", "pa ss word\n", "pa ss word"},
+		{"перевод строки Windows", "pa ss\r\n", "pa ss"},
+		{"без перевода строки", "pa ss", "pa ss"},
+		{"только первая строка", "first line\nsecond line\n", "first line"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := readPasswordLine(bufio.NewReader(strings.NewReader(test.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if value != test.value {
+				t.Errorf("readPasswordLine(%q) = %q, want %q", test.input, value, test.value)
+			}
+		})
+	}
+
+	if _, err := readPasswordLine(bufio.NewReader(strings.NewReader(""))); err == nil {
+		t.Error("readPasswordLine accepted empty input")
+	}
+}
@@ -0,0 +1,442 @@
+package core
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+)
+
+// Шаблон имени контейнера:
+//
+//	{subject.surname}                 - значение поля
+//	{subject.title|default:"Сотрудник"} - значение по умолчанию для отсутствующего поля
+//	{subject.title|upper|truncate:10} - фильтры применяются слева направо
+//	{expire_after|date:"YYYY-MM-DD"}  - формат даты
+//	[ - {subject.title}]              - сегмент выводится, только если все поля внутри заполнены
+//	#subject.surname                  - прежний синтаксис тегов
+//
+// Символы {, }, [, ], # и \ экранируются обратной косой чертой.
+
+var ErrTemplateSyntax = errors.New("template syntax error")
+
+const DEFAULT_DATE_FORMAT = "02.01.2006"
+
+var templateSubjectNames = []string{
+	"common_name",
+	"surname",
+	"country_name",
+	"locality_name",
+	"state_or_province_name",
+	"street_address",
+	"organization_name",
+	"organizational_unit_name",
+	"title",
+	"telephone_number",
+	"name",
+	"given_name",
+	"initials",
+	"pseudonym",
+	"email_address",
+	"serial_number",
+	"inn",
+	"innle",
+	"ogrn",
+	"ogrnip",
+	"snils",
+}
+
+// NameCertificate - данные сертификата для шаблона имени: разобранный ГОСТ сертификат
+// и поля, которых нет в cades.GostCertificate
+type NameCertificate struct {
+	*cades.GostCertificate
+	IssuerKeyId string
+}
+
+func NewNameCertificate(certificate *x509.Certificate) (*NameCertificate, error) {
+	gostCertificate, err := cades.ParseGostCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NameCertificate{
+		GostCertificate: gostCertificate,
+		IssuerKeyId:     strings.ToUpper(hex.EncodeToString(certificate.AuthorityKeyId)),
+	}, nil
+}
+
+type templateValue struct {
+	Text   string
+	Time   time.Time
+	IsTime bool
+}
+
+// TemplateResult - результат подстановки шаблона, Missing содержит поля без значения
+type TemplateResult struct {
+	Text    string
+	Missing []string
+}
+
+func isTemplateName(name string) bool {
+	for _, n := range templateSubjectNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func getInitials(names map[string]string) string {
+	givenName, ok := names["given_name"]
+	if !ok {
+		return ""
+	}
+
+	var initials string
+	for _, part := range strings.Fields(givenName) {
+		r, _ := utf8.DecodeRuneInString(part)
+		initials += string(r) + "."
+	}
+	return initials
+}
+
+// serialNumberHex переводит десятичный серийный номер из cades.GostCertificate в шестнадцатеричный, как в КриптоПро
+func serialNumberHex(serialNumber string) string {
+	number, ok := new(big.Int).SetString(serialNumber, 10)
+	if !ok {
+		return serialNumber
+	}
+
+	text := strings.ToUpper(number.Text(16))
+	if len(text)%2 != 0 {
+		text = "0" + text
+	}
+	return text
+}
+
+func parseTemplateOid(value string) (asn1.ObjectIdentifier, bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) < 2 {
+		return nil, false
+	}
+
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part == "" {
+			return nil, false
+		}
+		oid[i] = n
+	}
+	return oid, true
+}
+
+// lookupOidField ищет атрибут по OID, известные OID хранятся в cades.GostCertificate под своими именами
+func lookupOidField(names map[string]string, value string) (templateValue, bool) {
+	oid, ok := parseTemplateOid(value)
+	if !ok {
+		return templateValue{}, false
+	}
+
+	if name, ok := cades.SubjectAndIssuerNames[oid.String()]; ok {
+		return templateValue{Text: names[name]}, true
+	}
+	return templateValue{Text: names[oid.String()]}, true
+}
+
+// lookupTemplateField возвращает значение поля и признак того, что поле поддерживается шаблоном
+func lookupTemplateField(certificate *NameCertificate, key string) (templateValue, bool) {
+	switch key {
+	case "expire_after":
+		return templateValue{Text: certificate.NotAfter.Format(DEFAULT_DATE_FORMAT), Time: certificate.NotAfter, IsTime: true}, true
+	case "expire_before":
+		return templateValue{Text: certificate.NotBefore.Format(DEFAULT_DATE_FORMAT), Time: certificate.NotBefore, IsTime: true}, true
+	case "serial":
+		return templateValue{Text: serialNumberHex(certificate.SerialNumber)}, true
+	case "thumbprint":
+		return templateValue{Text: strings.ToUpper(certificate.Thumbprint)}, true
+	case "issuer_key_id":
+		return templateValue{Text: certificate.IssuerKeyId}, true
+	}
+
+	group, name, ok := strings.Cut(key, ".")
+	if !ok || (!isTemplateName(name) && !strings.HasPrefix(name, "oid.")) {
+		return templateValue{}, false
+	}
+
+	var names map[string]string
+	switch group {
+	case "subject":
+		names = certificate.Subject
+	case "issuer":
+		names = certificate.Issuer
+	default:
+		return templateValue{}, false
+	}
+
+	if oid, ok := strings.CutPrefix(name, "oid."); ok {
+		return lookupOidField(names, oid)
+	}
+
+	if v, ok := names[name]; ok {
+		return templateValue{Text: v}, true
+	}
+
+	if name == "initials" {
+		return templateValue{Text: getInitials(names)}, true
+	}
+	return templateValue{}, true
+}
+
+// convertDateFormat переводит формат вида DD.MM.YYYY hh:mm:ss в формат Go
+func convertDateFormat(format string) string {
+	replacer := strings.NewReplacer(
+		"YYYY", "2006",
+		"YY", "06",
+		"MM", "01",
+		"DD", "02",
+		"hh", "15",
+		"mm", "04",
+		"ss", "05",
+	)
+	return replacer.Replace(format)
+}
+
+func applyTemplateFilter(value templateValue, filter string, arg string, hasArg bool) (templateValue, error) {
+	switch filter {
+	case "upper":
+		value.Text = strings.ToUpper(value.Text)
+	case "lower":
+		value.Text = strings.ToLower(value.Text)
+	case "truncate":
+		length, err := strconv.Atoi(arg)
+		if err != nil || length < 0 {
+			return value, fmt.Errorf("%w: truncate ожидает число, получено %q", ErrTemplateSyntax, arg)
+		}
+
+		if r := []rune(value.Text); len(r) > length {
+			value.Text = strings.TrimSpace(string(r[:length]))
+		}
+	case "date":
+		if !hasArg {
+			return value, fmt.Errorf("%w: date ожидает формат, например date:\"DD.MM.YYYY\"", ErrTemplateSyntax)
+		}
+
+		if value.IsTime {
+			value.Text = value.Time.Format(convertDateFormat(arg))
+		}
+	case "default":
+		if value.Text == "" {
+			value.Text = arg
+		}
+	default:
+		return value, fmt.Errorf("%w: неизвестный фильтр %q", ErrTemplateSyntax, filter)
+	}
+	return value, nil
+}
+
+// splitTemplateExpression разделяет выражение по "|" без учета разделителей в кавычках
+func splitTemplateExpression(expression string) []string {
+	var parts []string
+	var current strings.Builder
+	inQuotes := false
+
+	for i := 0; i < len(expression); i++ {
+		c := expression[i]
+		switch {
+		case c == '\\' && inQuotes && i+1 < len(expression):
+			i++
+			current.WriteByte(expression[i])
+			continue
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '|' && !inQuotes:
+			parts = append(parts, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	return append(parts, current.String())
+}
+
+// findExpressionEnd возвращает индекс закрывающей скобки выражения без учета "}" в кавычках
+func findExpressionEnd(pattern string) int {
+	inQuotes := false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && inQuotes:
+			i++
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '}' && !inQuotes:
+			return i
+		}
+	}
+	return -1
+}
+
+func parseTemplateFilter(part string) (string, string, bool) {
+	name, arg, hasArg := strings.Cut(strings.TrimSpace(part), ":")
+	arg = strings.TrimSpace(arg)
+	if len(arg) >= 2 && strings.HasPrefix(arg, `"`) && strings.HasSuffix(arg, `"`) {
+		arg = arg[1 : len(arg)-1]
+	}
+	return strings.TrimSpace(name), arg, hasArg
+}
+
+type templateRenderer struct {
+	certificate *NameCertificate
+	pattern     string
+	pos         int
+	fields      int
+	missing     []string
+}
+
+func (r *templateRenderer) evaluate(expression string) (string, bool, error) {
+	parts := splitTemplateExpression(expression)
+	key := strings.TrimSpace(parts[0])
+
+	value, ok := lookupTemplateField(r.certificate, key)
+	if !ok {
+		return "", false, fmt.Errorf("%w: неизвестное поле %q", ErrTemplateSyntax, key)
+	}
+
+	var err error
+	for _, part := range parts[1:] {
+		filter, arg, hasArg := parseTemplateFilter(part)
+		value, err = applyTemplateFilter(value, filter, arg, hasArg)
+		if err != nil {
+			return "", false, err
+		}
+	}
+	return value.Text, value.Text != "", nil
+}
+
+// legacyTag находит самый длинный известный тег после #, чтобы #subject.name не совпал с началом другого тега
+func (r *templateRenderer) legacyTag() (string, bool) {
+	end := r.pos
+	for end < len(r.pattern) {
+		c := r.pattern[end]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.') {
+			break
+		}
+		end++
+	}
+
+	for ; end > r.pos; end-- {
+		key := r.pattern[r.pos:end]
+		if _, ok := lookupTemplateField(r.certificate, key); ok {
+			r.pos = end
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// render разбирает шаблон до закрывающей скобки сегмента (если inSegment) или до конца строки.
+// Возвращает текст и признак того, что все поля внутри заполнены.
+func (r *templateRenderer) render(inSegment bool) (string, bool, error) {
+	var builder strings.Builder
+	complete := true
+
+	for r.pos < len(r.pattern) {
+		c := r.pattern[r.pos]
+		switch c {
+		case '\\':
+			if r.pos+1 < len(r.pattern) {
+				r.pos++
+				builder.WriteByte(r.pattern[r.pos])
+			}
+			r.pos++
+
+		case '{':
+			end := findExpressionEnd(r.pattern[r.pos:])
+			if end < 0 {
+				return "", false, fmt.Errorf("%w: не закрыта скобка { в позиции %d", ErrTemplateSyntax, r.pos+1)
+			}
+
+			expression := r.pattern[r.pos+1 : r.pos+end]
+			r.pos += end + 1
+
+			text, ok, err := r.evaluate(expression)
+			if err != nil {
+				return "", false, err
+			}
+
+			r.fields++
+			if !ok {
+				complete = false
+				r.missing = append(r.missing, strings.TrimSpace(splitTemplateExpression(expression)[0]))
+			}
+			builder.WriteString(text)
+
+		case '#':
+			r.pos++
+			key, ok := r.legacyTag()
+			if !ok {
+				builder.WriteByte(c)
+				continue
+			}
+
+			r.fields++
+			value, _ := lookupTemplateField(r.certificate, key)
+			if value.Text == "" {
+				complete = false
+				r.missing = append(r.missing, key)
+			}
+			builder.WriteString(value.Text)
+
+		case '[':
+			r.pos++
+			missingStart, fieldsStart := len(r.missing), r.fields
+			text, ok, err := r.render(true)
+			if err != nil {
+				return "", false, err
+			}
+
+			// Поля необязательного сегмента не считаются пропущенными
+			r.missing = r.missing[:missingStart]
+			if r.fields == fieldsStart {
+				// Скобки без полей внутри - обычный текст, как в шаблонах до появления сегментов
+				builder.WriteString("[" + text + "]")
+			} else if ok {
+				builder.WriteString(text)
+			}
+
+		case ']':
+			if !inSegment {
+				return "", false, fmt.Errorf("%w: лишняя скобка ] в позиции %d", ErrTemplateSyntax, r.pos+1)
+			}
+			r.pos++
+			return builder.String(), complete, nil
+
+		default:
+			builder.WriteByte(c)
+			r.pos++
+		}
+	}
+
+	if inSegment {
+		return "", false, fmt.Errorf("%w: не закрыта скобка [", ErrTemplateSyntax)
+	}
+	return builder.String(), complete, nil
+}
+
+// RenderNamePattern подставляет данные сертификата в шаблон имени
+func RenderNamePattern(pattern string, certificate *NameCertificate) (*TemplateResult, error) {
+	renderer := &templateRenderer{certificate: certificate, pattern: pattern}
+	text, _, err := renderer.render(false)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateResult{Text: text, Missing: renderer.missing}, nil
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	cades "github.com/Demetrous-fd/CryptoPro-Adapter"
+)
+
+func newTestNameCertificate() *NameCertificate {
+	return &NameCertificate{
+		GostCertificate: &cades.GostCertificate{
+			Subject: map[string]string{
+				"common_name":       "Иванов Иван Иванович",
+				"surname":           "Иванов",
+				"given_name":        "Иван Иванович",
+				"organization_name": `ООО "Ромашка"`,
+				"inn":               "007700000000",
+			},
+			Issuer: map[string]string{
+				"common_name": "Тестовый УЦ",
+			},
+			NotBefore:    time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+			NotAfter:     time.Date(2025, 3, 4, 15, 30, 0, 0, time.UTC),
+			SerialNumber: "255",
+			Thumbprint:   "0a1b2c3d4e5f",
+		},
+		IssuerKeyId: "ABCDEF",
+	}
+}
+
+func TestRenderNamePattern(t *testing.T) {
+	certificate := newTestNameCertificate()
+
+	tests := []struct {
+		name    string
+		pattern string
+		text    string
+		missing []string
+	}{
+		{"поле", "{subject.surname}", "Иванов", nil},
+		{"инициалы", "{subject.surname} {subject.initials}", "Иванов И.И.", nil},
+		{"пустое поле", "{subject.title}", "", []string{"subject.title"}},
+		{"фильтр upper", "{subject.surname|upper}", "ИВАНОВ", nil},
+		{"фильтр lower", "{issuer.common_name|lower}", "тестовый уц", nil},
+		{"фильтры слева направо", "{subject.common_name|upper|truncate:6}", "ИВАНОВ", nil},
+		{"truncate без пробела в конце", "{subject.common_name|truncate:7}", "Иванов", nil},
+		{"default", `{subject.title|default:"Сотрудник"}`, "Сотрудник", nil},
+		{"default со скобкой", `{subject.title|default:"a}b"} x`, "a}b x", nil},
+		{"default с разделителем", `{subject.title|default:"a|b"}`, "a|b", nil},
+		{"дата по умолчанию", "{expire_after}", "04.03.2025", nil},
+		{"формат даты", `{expire_before|date:"YYYY-MM-DD hh:mm"}`, "2024-01-02 10:00", nil},
+		{"серийный номер", "{serial}", "FF", nil},
+		{"отпечаток", "{thumbprint}", "0A1B2C3D4E5F", nil},
+		{"ключ издателя", "{issuer_key_id}", "ABCDEF", nil},
+		{"OID", "{subject.oid.1.2.643.3.131.1.1}", "007700000000", nil},
+		{"заполненный сегмент", "{subject.surname}[ - {subject.inn}]", "Иванов - 007700000000", nil},
+		{"пустой сегмент", "{subject.surname}[ - {subject.title}]", "Иванов", nil},
+		{"вложенный сегмент", "{subject.surname}[ ({subject.inn}[, {subject.title}])]", "Иванов (007700000000)", nil},
+		{"скобки без полей", "[Копия] {subject.surname}", "[Копия] Иванов", nil},
+		{"прежние теги", "#subject.surname #subject.initials", "Иванов И.И.", nil},
+		{"прежний тег без значения", "#subject.title-1", "-1", []string{"subject.title"}},
+		{"самый длинный тег", "#subject.given_name", "Иван Иванович", nil},
+		{"неизвестный тег", "#tag", "#tag", nil},
+		{"экранирование", `\{subject.surname\} \[x\] \#`, "{subject.surname} [x] #", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := RenderNamePattern(test.pattern, certificate)
+			if err != nil {
+				t.Fatalf("RenderNamePattern(%q) error: %s", test.pattern, err)
+			}
+
+			if result.Text != test.text {
+				t.Errorf("RenderNamePattern(%q) = %q, want %q", test.pattern, result.Text, test.text)
+			}
+
+			if (len(result.Missing) > 0 || len(test.missing) > 0) && !reflect.DeepEqual(result.Missing, test.missing) {
+				t.Errorf("RenderNamePattern(%q) missing = %v, want %v", test.pattern, result.Missing, test.missing)
+			}
+		})
+	}
+}
+
+func TestRenderNamePatternSyntaxError(t *testing.T) {
+	certificate := newTestNameCertificate()
+
+	patterns := []string{
+		"{subject.surname",
+		"{subject.unknown}",
+		"{unknown}",
+		"{subject.surname|reverse}",
+		"{subject.surname|truncate:x}",
+		"{expire_after|date}",
+		"[{subject.surname}",
+		"{subject.surname}]",
+	}
+
+	for _, pattern := range patterns {
+		t.Run(pattern, func(t *testing.T) {
+			_, err := RenderNamePattern(pattern, certificate)
+			if !errors.Is(err, ErrTemplateSyntax) {
+				t.Errorf("RenderNamePattern(%q) error = %v, want ErrTemplateSyntax", pattern, err)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package core
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	"os/user"
@@ -16,21 +15,46 @@ import (
 var CONTAINER_PATTERN = regexp.MustCompile(`(?m)^\\\\\.\\.*\\.*$`)
 
 type SettingsDefaultBlock struct {
-	NamePattern *string `json:"namePattern,omitempty"`
-	PfxPassword *string `json:"pfxPassword,omitempty"`
-	Exportable  *bool   `json:"exportable,omitempty"`
+	NamePattern            *string `json:"namePattern,omitempty"`
+	PfxPassword            *string `json:"pfxPassword,omitempty"`
+	Exportable             *bool   `json:"exportable,omitempty"`
+	InstallAllCertificates *bool   `json:"installAllCertificates,omitempty"`
+	Store                  *string `json:"store,omitempty"`
+	NameCollisionPolicy    *string `json:"nameCollisionPolicy,omitempty"`
+	ContainerPin           *string `json:"containerPin,omitempty"`
+	NewContainerPin        *string `json:"newContainerPin,omitempty"`
+	ClearContainerPin      *bool   `json:"clearContainerPin,omitempty"`
 }
 
 type SettingsArgsBlock struct {
-	Exportable *bool `json:"exportable,omitempty"`
-	SkipRoot   *bool `json:"skipRoot,omitempty"`
-	SkipWait   *bool `json:"skipWait,omitempty"`
-	Debug      *bool `json:"debug,omitempty"`
+	Exportable      *bool    `json:"exportable,omitempty"`
+	SkipRoot        *bool    `json:"skipRoot,omitempty"`
+	InstallChain    *bool    `json:"installChain,omitempty"`
+	SkipWait        *bool    `json:"skipWait,omitempty"`
+	Debug           *bool    `json:"debug,omitempty"`
+	NoCache         *bool    `json:"noCache,omitempty"`
+	ManifestPolicy  *string  `json:"manifestPolicy,omitempty"`
+	DuplicatePolicy *string  `json:"duplicatePolicy,omitempty"`
+	Include         []string `json:"include,omitempty"`
+	Exclude         []string `json:"exclude,omitempty"`
+}
+
+// SettingsChecksBlock задает действие (warn, skip, fail) для каждой проверки сертификата перед установкой
+type SettingsChecksBlock struct {
+	KeyAlgorithm             *string  `json:"keyAlgorithm,omitempty"`
+	SignatureAlgorithm       *string  `json:"signatureAlgorithm,omitempty"`
+	KeyUsage                 *string  `json:"keyUsage,omitempty"`
+	ExtendedKeyUsage         *string  `json:"extendedKeyUsage,omitempty"`
+	RequiredExtendedKeyUsage []string `json:"requiredExtendedKeyUsage,omitempty"`
+	Expired                  *string  `json:"expired,omitempty"`
+	NotYetValid              *string  `json:"notYetValid,omitempty"`
+	Revoked                  *string  `json:"revoked,omitempty"`
 }
 
 type Settings struct {
 	Default SettingsDefaultBlock
 	Args    SettingsArgsBlock
+	Checks  SettingsChecksBlock
 	Items   *[]*ESignatureInstallParams
 }
 
@@ -59,19 +83,33 @@ func GetRootContainersFolder(virtualDiskPath string) (string, error) {
 	return rootContainersFolder, nil
 }
 
+func isFilePathExists(path string) bool {
+	if _, err := os.Stat(path); err == nil {
+		return true
+	}
+
+	// Путь может указывать на файл внутри zip архива
+	resolvedPath, err := ResolveArchivePath(path)
+	if err != nil || resolvedPath == path {
+		return false
+	}
+	_, err = os.Stat(resolvedPath)
+	return err == nil
+}
+
 func GetFilePath(path string, certPath string) (string, error) {
 	var filePath string
-	if _, err := os.Stat(path); err == nil {
+	if isFilePathExists(path) {
 		return path, nil
 	}
 
 	filePath = filepath.Join(certPath, path)
-	if _, err := os.Stat(filePath); err == nil {
+	if isFilePathExists(filePath) {
 		return filePath, nil
 	}
 
 	filePath = filepath.Join(filepath.Dir(certPath), path)
-	if _, err := os.Stat(filePath); err == nil {
+	if isFilePathExists(filePath) {
 		return filePath, nil
 	}
 
@@ -113,24 +151,10 @@ func DeclOfNum(number int) string {
 }
 
 func IsPrivateKeyMalformed(containerPath string) bool {
-	files := []string{
-		"header.key",
-		"masks.key",
-		"masks2.key",
-		"primary.key",
-		"primary2.key",
-	}
-
-	for _, file := range files {
-		filePath := filepath.Join(containerPath, file)
-		fileInfo, err := os.Stat(filePath)
-		if errors.Is(err, os.ErrNotExist) {
-			slog.Debug(fmt.Sprintf("Контейнер[%s] поврежден: файл[%s] не существует", containerPath, file))
-			return true
-		} else if fileInfo.Size() <= 0 {
-			slog.Debug(fmt.Sprintf("Контейнер[%s] поврежден: файл[%s] пустой", containerPath, file))
-			return true
-		}
+	err := ValidateContainer(containerPath, nil)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Контейнер[%s] поврежден: %s", containerPath, err))
+		return true
 	}
 	return false
 }
@@ -8,16 +8,18 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/lmittmann/tint v0.3.4
 	github.com/mattn/go-colorable v0.1.14
+	github.com/otiai10/copy v1.14.1
 	github.com/samber/slog-multi v0.6.1
+	golang.org/x/crypto v0.32.0
 	golang.org/x/exp v0.0.0-20240719175910-8a7402abbf56
-	golang.org/x/text v0.14.0
+	golang.org/x/term v0.28.0
+	golang.org/x/text v0.21.0
 )
 
 require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/otiai10/copy v1.14.1 // indirect
 	github.com/otiai10/mint v1.6.3 // indirect
 	github.com/samber/lo v1.38.1 // indirect
-	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 )
@@ -14,6 +14,10 @@ func DefaultHelpUsage() {
 
 	fmt.Fprintln(os.Stderr, "\nCommands:")
 	fmt.Fprintln(os.Stderr, "  install - Установка электронной подписи")
+	fmt.Fprintln(os.Stderr, "  secrets - Управление файлом секретов (set, delete, list)")
+	fmt.Fprintln(os.Stderr, "  manifest - Создание и проверка манифеста целостности папки certs (create, verify)")
+	fmt.Fprintln(os.Stderr, "  name - Предпросмотр имен контейнеров по шаблону (preview)")
+	fmt.Fprintln(os.Stderr, "  root - Предпросмотр хранилищ для сертификатов из папки root (preview)")
 
 	fmt.Fprintln(os.Stderr, "\nFlags:")
 	flag.PrintDefaults()
@@ -22,10 +26,26 @@ func DefaultHelpUsage() {
 	fmt.Fprintf(os.Stderr, "Запустите `cpmass <command> -h` чтобы получить справку по определенной команде\n\n")
 }
 
+// CommandActionUsage возвращает справку для команд, которым требуется действие (secrets set, name preview, ...)
+func CommandActionUsage(cmd string) (func(), bool) {
+	switch cmd {
+	case "secrets":
+		return SecretsHelpUsage, true
+	case "manifest":
+		return ManifestHelpUsage, true
+	case "name":
+		return NameHelpUsage, true
+	case "root":
+		return RootHelpUsage, true
+	default:
+		return nil, false
+	}
+}
+
 func InstallHelpUsage() {
 	intro := `
 Использование:
-  cpmass install -cont "..." -cert "..." [flags]`
+  cpmass install -cont "..." [-cert "..."] [flags]`
 	fmt.Fprintln(os.Stderr, intro)
 
 	fmt.Fprintln(os.Stderr, "\nFlags:")
@@ -33,3 +53,57 @@ func InstallHelpUsage() {
 
 	fmt.Fprintln(os.Stderr)
 }
+
+func SecretsHelpUsage() {
+	intro := `
+Использование:
+  cpmass secrets set -name "..."
+  cpmass secrets delete -name "..."
+  cpmass secrets list
+
+Пароль от файла секретов запрашивается при запуске или берется из переменной окружения CPMASS_SECRETS_PASSWORD`
+	fmt.Fprintln(os.Stderr, intro)
+
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	SecretsFlagSet.PrintDefaults()
+
+	fmt.Fprintln(os.Stderr)
+}
+
+func ManifestHelpUsage() {
+	intro := `
+Использование:
+  cpmass manifest create [-format json|sha256sums]
+  cpmass manifest verify`
+	fmt.Fprintln(os.Stderr, intro)
+
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	ManifestFlagSet.PrintDefaults()
+
+	fmt.Fprintln(os.Stderr)
+}
+
+func NameHelpUsage() {
+	intro := `
+Использование:
+  cpmass name preview [-pattern "..."] [-cert "..."]
+
+Хранилище не изменяется`
+	fmt.Fprintln(os.Stderr, intro)
+
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	NameFlagSet.PrintDefaults()
+
+	fmt.Fprintln(os.Stderr)
+}
+
+func RootHelpUsage() {
+	intro := `
+Использование:
+  cpmass root preview
+
+Самоподписанные сертификаты устанавливаются в корневые (uRoot), остальные - в промежуточные (uCA).
+Хранилище не изменяется`
+	fmt.Fprintln(os.Stderr, intro)
+	fmt.Fprintln(os.Stderr)
+}
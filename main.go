@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/lmittmann/tint"
@@ -23,32 +25,91 @@ var (
 	debugFlag               *bool
 	skipWaitFlag            *bool
 	skipRootFlag            *bool
+	installChainFlag        *bool
+	noCacheFlag             *bool
+	storeFlag               *string
+	nameCollisionFlag       *string
+	manifestPolicyFlag      *string
+	duplicatePolicyFlag     *string
+	includeFlag             stringListFlag
+	excludeFlag             stringListFlag
 	containerPathInstallArg *string
 	containerNameInstallArg *string
 	certificatePathArg      *string
 	pfxPasswordInstallArg   *string
+	containerPinInstallArg  *string
+	newPinInstallArg        *string
+	clearPinInstallArg      *bool
+	storeInstallArg         *string
 	containerExportableArg  *bool
+	secretNameArg           *string
+	manifestFormatArg       *string
+	namePatternArg          *string
+	nameCertificateArg      *string
 	InstallFlagSet          *flag.FlagSet
+	SecretsFlagSet          *flag.FlagSet
+	ManifestFlagSet         *flag.FlagSet
+	NameFlagSet             *flag.FlagSet
 )
 
 const (
 	MASS_VERSION = "1.6.1"
 )
 
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*f = append(*f, v)
+		}
+	}
+	return nil
+}
+
 func init() {
 	flag.Usage = DefaultHelpUsage
 	versionFlag = flag.Bool("version", false, "Отобразить версию программы")
 	debugFlag = flag.Bool("debug", false, "Включить отладочную информацию в консоли")
 	skipWaitFlag = flag.Bool("skip-wait", false, "Пропустить ожидание перед выходом")
-	skipRootFlag = flag.Bool("skip-root", false, "Пропустить установку корневых сертификатов")
+	skipRootFlag = flag.Bool("skip-root", false, "Пропустить установку корневых сертификатов и списков отзыва")
+	installChainFlag = flag.Bool("install-chain", false, "Устанавливать из папки root только сертификаты, нужные для цепочек устанавливаемых подписей")
+	storeFlag = flag.String("store", "", "Считыватель для установки контейнеров: REGISTRY, HDIMAGE или имя считывателя")
+	nameCollisionFlag = flag.String("name-collision", core.NAME_COLLISION_SUFFIX, "Действие при совпадении имени контейнера с установленным: suffix - добавить номер, thumbprint - добавить начало отпечатка, overwrite - заменить, skip - пропустить подпись")
+	noCacheFlag = flag.Bool("no-cache", false, "Не использовать кэш автоматического поиска пар")
 	containerExportableArg = flag.Bool("exportable", false, "Разрешить экспорт контейнеров")
+	duplicatePolicyFlag = flag.String("duplicate-policy", core.DUPLICATE_POLICY_FOLDER, "Выбор при совпадении ключа у нескольких файлов: newest - самый новый, folder - контейнер в папке вместо pfx, ask - спросить")
+	flag.Var(&includeFlag, "include", "Шаблон файлов для автоматического поиска пар, можно указать несколько через запятую или повторить флаг")
+	flag.Var(&excludeFlag, "exclude", "Шаблон файлов и папок, исключаемых из автоматического поиска пар (в дополнение к certs/.cpmassignore)")
+	manifestPolicyFlag = flag.String("manifest-policy", core.MANIFEST_POLICY_SKIP, "Действие при несовпадении файлов с манифестом: skip - пропустить подпись, abort - прервать установку")
 
 	InstallFlagSet = flag.NewFlagSet("install", flag.ExitOnError)
 	InstallFlagSet.Usage = InstallHelpUsage
 	containerPathInstallArg = InstallFlagSet.String("cont", "", "[Требуется] Путь до pfx/папки контейнера")
-	certificatePathArg = InstallFlagSet.String("cert", "", "[Требуется] Путь до файла сертификата")
+	certificatePathArg = InstallFlagSet.String("cert", "", "Путь до файла сертификата, если не указан - используется сертификат из контейнера")
 	containerNameInstallArg = InstallFlagSet.String("name", "", "Название контейнера")
-	pfxPasswordInstallArg = InstallFlagSet.String("pfx_pass", "", "Пароль от pfx контейнера")
+	pfxPasswordInstallArg = InstallFlagSet.String("pfx_pass", "", "Пароль от pfx контейнера или ссылка на секрет (env:NAME, file:path, prompt, secret:NAME)")
+	containerPinInstallArg = InstallFlagSet.String("pin", "", "PIN-код контейнера или ссылка на секрет")
+	newPinInstallArg = InstallFlagSet.String("new_pin", "", "Новый PIN-код установленного контейнера или ссылка на секрет")
+	storeInstallArg = InstallFlagSet.String("store", "", "Считыватель для установки контейнера: REGISTRY, HDIMAGE или имя считывателя")
+	clearPinInstallArg = InstallFlagSet.Bool("clear_pin", false, "Снять PIN-код с установленного контейнера")
+
+	SecretsFlagSet = flag.NewFlagSet("secrets", flag.ExitOnError)
+	SecretsFlagSet.Usage = SecretsHelpUsage
+	secretNameArg = SecretsFlagSet.String("name", "", "Название записи в файле секретов")
+
+	ManifestFlagSet = flag.NewFlagSet("manifest", flag.ExitOnError)
+	ManifestFlagSet.Usage = ManifestHelpUsage
+	manifestFormatArg = ManifestFlagSet.String("format", "json", "Формат манифеста: json (manifest.json) или sha256sums (SHA256SUMS)")
+
+	NameFlagSet = flag.NewFlagSet("name", flag.ExitOnError)
+	NameFlagSet.Usage = NameHelpUsage
+	namePatternArg = NameFlagSet.String("pattern", "", "Шаблон имени контейнера, по умолчанию namePattern из settings.json")
+	nameCertificateArg = NameFlagSet.String("cert", "", "Путь до файла сертификата или папки, по умолчанию папка certs")
 }
 
 func main() {
@@ -59,12 +120,27 @@ func main() {
 
 	flag.Parse()
 	flagArgs := flag.Args()
+	if len(flagArgs) == 1 {
+		// Команда без действия не должна запускать полную установку
+		if usage, ok := CommandActionUsage(flagArgs[0]); ok {
+			usage()
+			code = 2
+			return
+		}
+	}
+
 	if len(flagArgs) > 1 {
 		cmd := flagArgs[0]
 		args := flagArgs[1:]
 		switch cmd {
 		case "install":
 			InstallFlagSet.Parse(args)
+		case "secrets":
+			SecretsFlagSet.Parse(args[1:])
+		case "manifest":
+			ManifestFlagSet.Parse(args[1:])
+		case "name":
+			NameFlagSet.Parse(args[1:])
 		default:
 		}
 	}
@@ -102,6 +178,22 @@ func main() {
 			if settings.Args.SkipRoot != nil {
 				skipRootFlag = settings.Args.SkipRoot
 			}
+
+			if settings.Args.InstallChain != nil {
+				installChainFlag = settings.Args.InstallChain
+			}
+
+			if settings.Args.NoCache != nil {
+				noCacheFlag = settings.Args.NoCache
+			}
+
+			if settings.Args.ManifestPolicy != nil {
+				manifestPolicyFlag = settings.Args.ManifestPolicy
+			}
+
+			if settings.Args.DuplicatePolicy != nil {
+				duplicatePolicyFlag = settings.Args.DuplicatePolicy
+			}
 		}
 	}
 
@@ -143,16 +235,92 @@ func main() {
 	}
 
 	logger := slog.New(
-		slogmulti.Fanout(
-			slog.NewTextHandler(logFile, &slog.HandlerOptions{Level: slog.LevelDebug}),
-			tint.NewHandler(consoleLoggerHandler, loggerOptions),
+		core.NewRedactHandler(
+			slogmulti.Fanout(
+				slog.NewTextHandler(logFile, &slog.HandlerOptions{Level: slog.LevelDebug}),
+				tint.NewHandler(consoleLoggerHandler, loggerOptions),
+			),
 		),
 	)
 	slog.SetDefault(logger)
 	slog.Debug(fmt.Sprintf("CryptoPro Mass Installer version %s", MASS_VERSION))
 
+	core.SetSecretsFilePath(filepath.Join(pwd, core.SECRETS_FILENAME))
+	if len(flagArgs) > 1 && flagArgs[0] == "secrets" {
+		err := RunSecretsCommand(flagArgs[1])
+		if err != nil {
+			code = 2
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	if !*noCacheFlag {
+		core.SetDiscoveryCachePath(filepath.Join(pwd, core.DISCOVERY_CACHE_FILENAME))
+	}
+
+	if err := core.SetCertificateChecks(settings.Checks); err != nil {
+		code = 2
+		slog.Error(fmt.Sprintf("Неизвестное действие проверки сертификата (%s), допустимо warn, skip или fail", err))
+		return
+	}
+	settings.Args.DuplicatePolicy = duplicatePolicyFlag
+	if settings.Default.Exportable == nil {
+		settings.Default.Exportable = containerExportableArg
+	}
+	if *storeFlag != "" && settings.Default.Store == nil {
+		settings.Default.Store = storeFlag
+	}
+	if settings.Default.NameCollisionPolicy == nil {
+		settings.Default.NameCollisionPolicy = nameCollisionFlag
+	}
+	settings.Args.Include = append(settings.Args.Include, includeFlag...)
+	settings.Args.Exclude = append(settings.Args.Exclude, excludeFlag...)
+
 	certsPath := filepath.Join(pwd, "certs")
 	_ = os.Mkdir(certsPath, os.ModePerm)
+	core.SetChainValidation(filepath.Join(certsPath, "root"), *installChainFlag)
+	core.SetRevocationCheck(certsPath)
+	defer core.CleanupTempFolder()
+
+	if len(flagArgs) > 1 && flagArgs[0] == "manifest" {
+		err := RunManifestCommand(flagArgs[1], certsPath)
+		if err != nil {
+			code = 2
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	if len(flagArgs) > 1 && flagArgs[0] == "name" {
+		err := RunNameCommand(flagArgs[1], certsPath, settings)
+		if err != nil {
+			code = 2
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	if len(flagArgs) > 1 && flagArgs[0] == "root" {
+		err := RunRootCommand(flagArgs[1], certsPath)
+		if err != nil {
+			code = 2
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	if _, err := core.ParseNameCollisionPolicy(*settings.Default.NameCollisionPolicy); err != nil {
+		code = 2
+		slog.Error(fmt.Sprintf("Неизвестная политика совпадения имени[%s], допустимо suffix, thumbprint, overwrite или skip", *settings.Default.NameCollisionPolicy))
+		return
+	}
+
+	err = core.CheckCertsManifest(certsPath, *manifestPolicyFlag)
+	if err != nil {
+		code = 2
+		return
+	}
 
 	rootContainersFolder, err := core.GetRootContainersFolder(certsPath)
 	if err != nil {
@@ -167,13 +335,31 @@ func main() {
 	// Parsing subcommand flags
 	if slices.Contains(flagArgs, "install") {
 		installParams := &core.ESignatureInstallParams{
-			ContainerPath:   *containerPathInstallArg,
-			ContainerName:   *containerNameInstallArg,
-			CertificatePath: *certificatePathArg,
-			PfxPassword:     pfxPasswordInstallArg,
-			Exportable:      containerExportableArg,
+			ContainerPath:       *containerPathInstallArg,
+			ContainerName:       *containerNameInstallArg,
+			CertificatePath:     *certificatePathArg,
+			PfxPassword:         pfxPasswordInstallArg,
+			Exportable:          containerExportableArg,
+			Store:               *storeFlag,
+			NameCollisionPolicy: *settings.Default.NameCollisionPolicy,
+		}
+		if isFlagPassed("name-collision") {
+			installParams.NameCollisionPolicy = *nameCollisionFlag
+		}
+		if *storeInstallArg != "" {
+			installParams.Store = *storeInstallArg
+		}
+		if *containerPinInstallArg != "" {
+			installParams.ContainerPin = containerPinInstallArg
+		}
+		if *newPinInstallArg != "" {
+			installParams.NewContainerPin = newPinInstallArg
+		}
+		if *clearPinInstallArg {
+			installParams.ClearContainerPin = clearPinInstallArg
 		}
 		err := core.InstallESignatureCLI(certsPath, rootContainersFolder, installParams, false)
+		core.CleanupTempFolder()
 		if err != nil {
 			code = 2
 			slog.Error(err.Error())
@@ -182,19 +368,164 @@ func main() {
 			core.AbsorbCertificatesFromContainers()
 		}
 	} else {
-		if !*skipRootFlag {
+		if !*skipRootFlag && !*installChainFlag {
 			core.InstallRootCertificates(certsPath)
 		}
+		if !*skipRootFlag {
+			core.InstallCRLs(certsPath)
+		}
 
-		core.InstallESignatureFromFile(certsPath, rootContainersFolder, settings)
+		if err := core.InstallESignatureFromFile(certsPath, rootContainersFolder, settings); err != nil {
+			code = 2
+		}
 		core.AbsorbCertificatesFromContainers()
 		if !*skipWaitFlag {
+			// На случай если пользователь вручную закроет окно
 			if runtime.GOOS == "windows" {
 				core.DeleteVirtualDisk(rootContainersFolder)
-			} // На случай если пользователь вручную закроет окно
+			}
+			core.CleanupTempFolder()
 
 			fmt.Print("\n\n\nУстановка сертификатов завершена, нажмите Enter:")
 			fmt.Scanln()
 		}
 	}
 }
+
+func RunSecretsCommand(action string) error {
+	switch action {
+	case "set":
+		if *secretNameArg == "" {
+			return errors.New("не указано название записи, используйте флаг -name")
+		}
+
+		value, err := core.ReadPassword(fmt.Sprintf("Введите значение для %s", *secretNameArg))
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			return errors.New("значение не может быть пустым, для удаления используйте команду delete")
+		}
+
+		err = core.SetSecret(*secretNameArg, value)
+		if err == nil {
+			slog.Info(fmt.Sprintf("Запись[%s] сохранена, используйте secret:%s в поле пароля", *secretNameArg, *secretNameArg))
+		}
+		return err
+	case "delete":
+		if *secretNameArg == "" {
+			return errors.New("не указано название записи, используйте флаг -name")
+		}
+
+		err := core.SetSecret(*secretNameArg, "")
+		if err == nil {
+			slog.Info(fmt.Sprintf("Запись[%s] удалена", *secretNameArg))
+		}
+		return err
+	case "list":
+		names, err := core.ListSecrets()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	default:
+		SecretsHelpUsage()
+		return fmt.Errorf("неизвестное действие: %s", action)
+	}
+}
+
+// isFlagPassed проверяет, что глобальный флаг указан в командной строке, а не взят по умолчанию
+func isFlagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+func RunManifestCommand(action string, certsPath string) error {
+	switch action {
+	case "create":
+		var manifestPath string
+		switch *manifestFormatArg {
+		case "json":
+			manifestPath = filepath.Join(certsPath, core.MANIFEST_JSON_FILENAME)
+		case "sha256sums":
+			manifestPath = filepath.Join(certsPath, core.MANIFEST_SHA256SUMS_FILENAME)
+		default:
+			return fmt.Errorf("неизвестный формат манифеста: %s", *manifestFormatArg)
+		}
+
+		manifest, err := core.CreateManifest(certsPath)
+		if err != nil {
+			return err
+		}
+
+		err = core.SaveManifest(manifest, manifestPath)
+		if err == nil {
+			slog.Info(fmt.Sprintf("Манифест[%s] создан, файлов: %d", manifestPath, len(manifest.Files)))
+		}
+		return err
+	case "verify":
+		if _, _, err := core.LoadManifest(certsPath); errors.Is(err, os.ErrNotExist) {
+			slog.Warn(fmt.Sprintf("Манифест (%s или %s) не найден в %s", core.MANIFEST_JSON_FILENAME, core.MANIFEST_SHA256SUMS_FILENAME, certsPath))
+			return nil
+		}
+		return core.CheckCertsManifest(certsPath, core.MANIFEST_POLICY_ABORT)
+	default:
+		ManifestHelpUsage()
+		return fmt.Errorf("неизвестное действие: %s", action)
+	}
+}
+
+func RunNameCommand(action string, certsPath string, settings core.Settings) error {
+	switch action {
+	case "preview":
+		pattern := *namePatternArg
+		if pattern == "" && settings.Default.NamePattern != nil {
+			pattern = *settings.Default.NamePattern
+		}
+		if pattern == "" {
+			return errors.New("не указан шаблон, используйте флаг -pattern или namePattern в settings.json")
+		}
+
+		path := certsPath
+		if *nameCertificateArg != "" {
+			certificatePath, err := core.GetFilePath(*nameCertificateArg, certsPath)
+			if err != nil {
+				return err
+			}
+			path = certificatePath
+		}
+
+		previews, err := core.PreviewNames(pattern, path)
+		if err != nil {
+			return err
+		}
+		core.PrintNamePreview(previews)
+		return nil
+	default:
+		NameHelpUsage()
+		return fmt.Errorf("неизвестное действие: %s", action)
+	}
+}
+
+func RunRootCommand(action string, certsPath string) error {
+	switch action {
+	case "preview":
+		previews, err := core.PreviewRootFolder(filepath.Join(certsPath, "root"))
+		if err != nil {
+			return err
+		}
+		core.PrintRootPreview(previews)
+		return nil
+	default:
+		RootHelpUsage()
+		return fmt.Errorf("неизвестное действие: %s", action)
+	}
+}